@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gviz/revDNS/internal/auth"
+	"github.com/gviz/revDNS/internal/revconfig"
+)
+
+//maxDenialKeys distinct keys denials are counted for, the rest are
+//counted as "other"
+const maxDenialKeys = 1024
+
+type ctxKey int
+
+const (
+	identityKey ctxKey = iota
+	auditKey
+)
+
+//newAuthenticator builds the api authenticator from the config. Tenant
+//api keys are identities limited to their tenant.
+func newAuthenticator(conf *revconfig.RevConfig) (*auth.Authenticator, error) {
+	a := auth.New()
+	if !conf.Auth.Enabled {
+		//admin routes always need credentials
+		a.SetAnonymous(&auth.Identity{
+			Name:    "anonymous",
+			Method:  auth.MethodNone,
+			Role:    auth.RoleReader,
+			Tenants: []string{defaultTenant},
+		})
+	}
+	for _, k := range conf.Auth.Keys {
+		if err := a.AddKey(k.Key, identity(k)); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range conf.Auth.Certs {
+		if err := a.AddCert(c.Name, identity(c)); err != nil {
+			return nil, err
+		}
+	}
+	for tenant, tc := range conf.Tenants {
+		role := tc.Role
+		if role == "" {
+			role = auth.RoleAdmin
+		}
+		for i, key := range tc.ApiKeys {
+			err := a.AddKey(key, auth.Identity{
+				Name:    fmt.Sprintf("%s/key%d", tenant, i+1),
+				Role:    role,
+				Tenants: []string{tenant},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if jwt := conf.Auth.JWT; jwt.JWKS != "" {
+		v, err := auth.NewJWTVerifier(jwt.JWKS, auth.JWTConfig{
+			Issuer:        jwt.Issuer,
+			Audience:      jwt.Audience,
+			RoleClaim:     jwt.RoleClaim,
+			TenantsClaim:  jwt.TenantsClaim,
+			DefaultTenant: defaultTenant,
+		})
+		if err != nil {
+			return nil, err
+		}
+		a.SetJWT(v)
+	}
+	return a, nil
+}
+
+//identity of a configured key or cert, the default tenant unless
+//tenants are listed
+func identity(k revconfig.AuthKey) auth.Identity {
+	id := auth.Identity{Name: k.Name, Role: k.Role}
+	for _, t := range k.Tenants {
+		id.Tenants = append(id.Tenants, strings.ToLower(t))
+	}
+	if len(id.Tenants) == 0 {
+		id.Tenants = []string{defaultTenant}
+	}
+	return id
+}
+
+//httpCredentials collects the api key, bearer token and verified
+//client certificate of a request
+func httpCredentials(req *http.Request) auth.Credentials {
+	c := auth.Credentials{APIKey: req.Header.Get("X-Api-Key")}
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		c.Bearer = strings.TrimSpace(h[len("Bearer "):])
+	}
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		c.Certs = req.TLS.VerifiedChains[0]
+	}
+	return c
+}
+
+//identityOf returns the caller of an authorized request
+func identityOf(req *http.Request) *auth.Identity {
+	id, _ := req.Context().Value(identityKey).(*auth.Identity)
+	return id
+}
+
+//access checks that the caller at addr holds role on tenant, denials
+//count against the address' rate limit
+func (r *revDns) access(c auth.Credentials, addr string, tenant string, role string, what string) (*auth.Identity, error) {
+	id, err := r.auth.Authenticate(c)
+	if err != nil {
+		who := "unknown"
+		if e, ok := err.(*auth.Error); ok {
+			who, err = e.Who, e.Err
+		}
+		r.deny(who, addr, what, err)
+		return nil, newAPIError(http.StatusUnauthorized, "unauthorized")
+	}
+	if !id.InTenant(tenant) || !id.Allowed(role) {
+		r.deny(id.Name, addr, what, fmt.Errorf("%s may not %s tenant %q", id.Role, role, tenant))
+		return nil, newAPIError(http.StatusForbidden, "forbidden")
+	}
+	if !r.validTenant(tenant) {
+		return nil, newAPIError(http.StatusNotFound, "unknown tenant %q", tenant)
+	}
+	return id, nil
+}
+
+//deny logs and counts a denied request per key and charges it to the
+//caller's address
+func (r *revDns) deny(who string, addr string, what string, err error) {
+	log.Printf("Denied %s %s: %s", who, what, err)
+	if r.limiter != nil {
+		r.limiter.denied(clientHost(addr))
+	}
+	r.authLock.Lock()
+	if _, ok := r.denials[who]; !ok && len(r.denials) >= maxDenialKeys {
+		who = "other"
+	}
+	r.denials[who]++
+	r.authLock.Unlock()
+}
+
+//denialCounts returns the denied requests per key
+func (r *revDns) denialCounts() map[string]uint64 {
+	r.authLock.Lock()
+	defer r.authLock.Unlock()
+	counts := make(map[string]uint64, len(r.denials))
+	for who, n := range r.denials {
+		counts[who] = n
+	}
+	return counts
+}
+
+//authorize admits callers holding role on the request's tenant
+func (r *revDns) authorize(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if wait := r.deniedWait(req.RemoteAddr); wait > 0 {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			writeError(w, errRateLimited(wait))
+			return
+		}
+		what := fmt.Sprintf("%s %s from %s", req.Method, req.URL.Path, req.RemoteAddr)
+		id, err := r.access(httpCredentials(req), req.RemoteAddr, tenantOf(req), role, what)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		ctx := context.WithValue(req.Context(), identityKey, id)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+//purgeRsp result of an admin purge
+type purgeRsp struct {
+	DryRun bool `json:"dry_run"`
+	revdb.PurgeResult
+}
+
+//newPurge reads the purge selectors of a request, the ip and domain
+//from the path, source, suffix and from/to from the query
+func newPurge(req *http.Request) (revdb.Purge, bool, error) {
+	var p revdb.Purge
+	vars, q := mux.Vars(req), req.URL.Query()
+	if ip, ok := vars["ip"]; ok {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return p, false, fmt.Errorf("invalid ip %q", ip)
+		}
+		p.IP = addr.String()
+	}
+	if domain, ok := vars["domain"]; ok {
+		if p.Domain = normalizeDomain(domain); p.Domain == "" {
+			return p, false, fmt.Errorf("invalid domain")
+		}
+	}
+	p.Suffix = q.Get("suffix") == "true"
+	p.Source = q.Get("source")
+	switch p.Source {
+	case "", revdb.SourceDNS, revdb.SourceSSL, revdb.SourceHTTP:
+	default:
+		return p, false, fmt.Errorf("unknown source %q", p.Source)
+	}
+	var err error
+	if p.From, err = purgeTime(q, "from"); err != nil {
+		return p, false, err
+	}
+	if p.To, err = purgeTime(q, "to"); err != nil {
+		return p, false, err
+	}
+	if p.To != 0 && p.From > p.To {
+		return p, false, fmt.Errorf("from is after to")
+	}
+	return p, q.Get("dry_run") == "true", nil
+}
+
+func purgeTime(q url.Values, name string) (int64, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	t, err := revdb.ParseTime(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", v)
+	}
+	return t.Unix(), nil
+}
+
+//purgeHandler removes polluted pairs. Every purge, dry runs included,
+//is logged with the identity that asked for it.
+func (r *revDns) purgeHandler(w http.ResponseWriter, req *http.Request) {
+	p, dryRun, err := newPurge(req)
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	if p.IP == "" && p.Domain == "" && p.Source == "" && p.From == 0 && p.To == 0 {
+		badRequest(w, "purge needs a domain, source or from/to")
+		return
+	}
+	tenant := tenantOf(req)
+	db, err := r.tenantDB(tenant)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	pr, ok := db.(revdb.Purger)
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotImplemented, "%s doesn't support purges", db))
+		return
+	}
+	res, err := pr.Purge(p, dryRun)
+	who := "unknown"
+	if id := identityOf(req); id != nil {
+		who = id.Name
+	}
+	if err != nil {
+		log.Printf("Audit: %s purge of %+v in %s failed after removing %d pairs from %d ips: %s\n",
+			who, p, tenant, res.Pairs, res.IPs, err)
+		writeError(w, err)
+		return
+	}
+	log.Printf("Audit: %s purged %+v in %s, dry run %t: %d ips, %d pairs removed, %d updated, %d ips deleted\n",
+		who, p, tenant, dryRun, res.IPs, res.Pairs, res.Updated, res.Deleted)
+	auditResults(req, res.Pairs)
+	if p.IP != "" && res.IPs == 0 {
+		writeError(w, errNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(purgeRsp{DryRun: dryRun, PurgeResult: res})
+}
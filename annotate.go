@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gviz/revDNS/internal/auth"
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+const (
+	maxNoteLen = 4096
+	maxTagLen  = 64
+	//Tags that set the ip's black/attacker flags on lookups
+	tagBlack    = "black"
+	tagAttacker = "attacker"
+)
+
+//annotationReq body of an annotation post
+type annotationReq struct {
+	Tags   []string `json:"tags"`
+	Note   string   `json:"note"`
+	Author string   `json:"author"`
+}
+
+func annotator(db revdb.DBIface) (revdb.Annotator, error) {
+	an, ok := db.(revdb.Annotator)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't support annotations", db)
+	}
+	return an, nil
+}
+
+//annotationTarget validates the annotated object of a request
+func annotationTarget(req *http.Request) (string, string, error) {
+	vars := mux.Vars(req)
+	kind, key := vars["kind"], vars["key"]
+	switch kind {
+	case revdb.AnnotateIP:
+		addr := net.ParseIP(key)
+		if addr == nil {
+			return kind, key, fmt.Errorf("invalid ip %q", key)
+		}
+		key = addr.String()
+	case revdb.AnnotateDomain:
+		if key = normalizeDomain(key); key == "" {
+			return kind, key, fmt.Errorf("invalid domain")
+		}
+	default:
+		return kind, key, fmt.Errorf("unknown kind %q", kind)
+	}
+	return kind, key, nil
+}
+
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || len(tag) > maxTagLen || strings.ContainsAny(tag, "\x00") {
+		return tag, fmt.Errorf("invalid tag %q", tag)
+	}
+	return tag, nil
+}
+
+//annotate attaches the annotations of the ip and its domains to val
+func annotate(db revdb.DBIface, ip string, val *revdb.DnsVal) error {
+	recs := []revdb.Record{{IP: ip, DnsVal: *val}}
+	if err := annotateAll(db, recs); err != nil {
+		return err
+	}
+	*val = recs[0].DnsVal
+	return nil
+}
+
+//annotateAll attaches annotations to recs, reading them in one batch per kind
+func annotateAll(db revdb.DBIface, recs []revdb.Record) error {
+	an, ok := db.(revdb.Annotator)
+	if !ok {
+		return nil
+	}
+	ips := make([]string, 0, len(recs))
+	//annotations are keyed by normalized names, the db keeps names as
+	//ingested
+	var names []string
+	seen := make(map[string]bool)
+	for _, rec := range recs {
+		ips = append(ips, rec.IP)
+		for name := range rec.Domains {
+			if key := normalizeDomain(name); !seen[key] {
+				seen[key] = true
+				names = append(names, key)
+			}
+		}
+	}
+	ipNotes, err := an.Annotations(revdb.AnnotateIP, ips)
+	if err != nil {
+		return err
+	}
+	domNotes, err := an.Annotations(revdb.AnnotateDomain, names)
+	if err != nil {
+		return err
+	}
+	for i := range recs {
+		val := &recs[i].DnsVal
+		notes := make(map[string][]revdb.Annotation)
+		if list, ok := ipNotes[recs[i].IP]; ok {
+			notes[recs[i].IP] = list
+		}
+		for name := range val.Domains {
+			if list, ok := domNotes[normalizeDomain(name)]; ok {
+				notes[name] = list
+			}
+		}
+		if len(notes) == 0 {
+			continue
+		}
+		val.Annotations = notes
+		val.Black = val.Black || revdb.HasTag(notes[recs[i].IP], tagBlack)
+		val.Attacker = val.Attacker || revdb.HasTag(notes[recs[i].IP], tagAttacker)
+	}
+	return nil
+}
+
+//addAnnotation stores an analyst annotation on an ip or domain
+func (r *revDns) addAnnotation(w http.ResponseWriter, req *http.Request) {
+	kind, key, err := annotationTarget(req)
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	var body annotationReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<16)).Decode(&body); err != nil {
+		badRequest(w, "invalid annotation: %s", err)
+		return
+	}
+	a := revdb.Annotation{
+		Note:   body.Note,
+		Author: strings.TrimSpace(body.Author),
+		Time:   time.Now().Unix(),
+	}
+	//authenticated callers can't annotate in someone else's name
+	if id := identityOf(req); id != nil && id.Method != auth.MethodNone {
+		a.Author = id.Name
+	}
+	if a.Author == "" {
+		badRequest(w, "author is required")
+		return
+	}
+	if len(a.Note) > maxNoteLen {
+		badRequest(w, "note too long")
+		return
+	}
+	for _, t := range body.Tags {
+		tag, err := normalizeTag(t)
+		if err != nil {
+			badRequest(w, "%s", err)
+			return
+		}
+		a.Tags = append(a.Tags, tag)
+	}
+	if len(a.Tags) == 0 && a.Note == "" {
+		badRequest(w, "tags or note required")
+		return
+	}
+
+	db, err := r.tenantDB(tenantOf(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	an, err := annotator(db)
+	if err == nil {
+		err = an.Annotate(kind, key, a)
+	}
+	if err != nil {
+		log.Println("Error storing annotation:", err)
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(revdb.AnnotationRef{
+		Kind:       kind,
+		Key:        key,
+		Annotation: a,
+	})
+}
+
+//getAnnotations lists the annotations of an ip or domain
+func (r *revDns) getAnnotations(w http.ResponseWriter, req *http.Request) {
+	kind, key, err := annotationTarget(req)
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	db, err := r.tenantDB(tenantOf(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	an, err := annotator(db)
+	var notes map[string][]revdb.Annotation
+	if err == nil {
+		notes, err = an.Annotations(kind, []string{key})
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	list := notes[key]
+	if list == nil {
+		list = []revdb.Annotation{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+//searchAnnotations finds annotations by ?tag=
+func (r *revDns) searchAnnotations(w http.ResponseWriter, req *http.Request) {
+	tag, err := normalizeTag(req.URL.Query().Get("tag"))
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	db, err := r.tenantDB(tenantOf(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	an, err := annotator(db)
+	var refs []revdb.AnnotationRef
+	if err == nil {
+		refs, err = an.SearchTag(tag)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if refs == nil {
+		refs = []revdb.AnnotationRef{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refs)
+}
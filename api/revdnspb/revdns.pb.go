@@ -0,0 +1,836 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: revdns.proto
+
+package revdnspb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// Requests for tenants other than "default" need the tenant's api key
+// in the x-api-key metadata.
+type IPRequest struct {
+	Tenant string `protobuf:"bytes,1,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Ip     string `protobuf:"bytes,2,opt,name=ip,proto3" json:"ip,omitempty"`
+	// only domains seen from source (dns, ssl, http)
+	Source string `protobuf:"bytes,3,opt,name=source,proto3" json:"source,omitempty"`
+	// only domains seen by sensor
+	Sensor string `protobuf:"bytes,4,opt,name=sensor,proto3" json:"sensor,omitempty"`
+	// only the top n ranked domains
+	Top                  int32    `protobuf:"varint,5,opt,name=top,proto3" json:"top,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IPRequest) Reset()         { *m = IPRequest{} }
+func (m *IPRequest) String() string { return proto.CompactTextString(m) }
+func (*IPRequest) ProtoMessage()    {}
+func (*IPRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_315ae8d1d23f45e4, []int{0}
+}
+
+func (m *IPRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IPRequest.Unmarshal(m, b)
+}
+func (m *IPRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IPRequest.Marshal(b, m, deterministic)
+}
+func (m *IPRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IPRequest.Merge(m, src)
+}
+func (m *IPRequest) XXX_Size() int {
+	return xxx_messageInfo_IPRequest.Size(m)
+}
+func (m *IPRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_IPRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IPRequest proto.InternalMessageInfo
+
+func (m *IPRequest) GetTenant() string {
+	if m != nil {
+		return m.Tenant
+	}
+	return ""
+}
+
+func (m *IPRequest) GetIp() string {
+	if m != nil {
+		return m.Ip
+	}
+	return ""
+}
+
+func (m *IPRequest) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *IPRequest) GetSensor() string {
+	if m != nil {
+		return m.Sensor
+	}
+	return ""
+}
+
+func (m *IPRequest) GetTop() int32 {
+	if m != nil {
+		return m.Top
+	}
+	return 0
+}
+
+type DomainInfo struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Wlid                 int32    `protobuf:"varint,2,opt,name=wlid,proto3" json:"wlid,omitempty"`
+	Sources              []string `protobuf:"bytes,3,rep,name=sources,proto3" json:"sources,omitempty"`
+	Sensors              []string `protobuf:"bytes,4,rep,name=sensors,proto3" json:"sensors,omitempty"`
+	Count                int64    `protobuf:"varint,5,opt,name=count,proto3" json:"count,omitempty"`
+	FirstSeen            int64    `protobuf:"varint,6,opt,name=first_seen,json=firstSeen,proto3" json:"first_seen,omitempty"`
+	LastSeen             int64    `protobuf:"varint,7,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DomainInfo) Reset()         { *m = DomainInfo{} }
+func (m *DomainInfo) String() string { return proto.CompactTextString(m) }
+func (*DomainInfo) ProtoMessage()    {}
+func (*DomainInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_315ae8d1d23f45e4, []int{1}
+}
+
+func (m *DomainInfo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DomainInfo.Unmarshal(m, b)
+}
+func (m *DomainInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DomainInfo.Marshal(b, m, deterministic)
+}
+func (m *DomainInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DomainInfo.Merge(m, src)
+}
+func (m *DomainInfo) XXX_Size() int {
+	return xxx_messageInfo_DomainInfo.Size(m)
+}
+func (m *DomainInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_DomainInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DomainInfo proto.InternalMessageInfo
+
+func (m *DomainInfo) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *DomainInfo) GetWlid() int32 {
+	if m != nil {
+		return m.Wlid
+	}
+	return 0
+}
+
+func (m *DomainInfo) GetSources() []string {
+	if m != nil {
+		return m.Sources
+	}
+	return nil
+}
+
+func (m *DomainInfo) GetSensors() []string {
+	if m != nil {
+		return m.Sensors
+	}
+	return nil
+}
+
+func (m *DomainInfo) GetCount() int64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *DomainInfo) GetFirstSeen() int64 {
+	if m != nil {
+		return m.FirstSeen
+	}
+	return 0
+}
+
+func (m *DomainInfo) GetLastSeen() int64 {
+	if m != nil {
+		return m.LastSeen
+	}
+	return 0
+}
+
+type IPReply struct {
+	Ip string `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	// false for unknown ips
+	Found    bool          `protobuf:"varint,2,opt,name=found,proto3" json:"found,omitempty"`
+	Black    bool          `protobuf:"varint,3,opt,name=black,proto3" json:"black,omitempty"`
+	Attacker bool          `protobuf:"varint,4,opt,name=attacker,proto3" json:"attacker,omitempty"`
+	Shared   bool          `protobuf:"varint,5,opt,name=shared,proto3" json:"shared,omitempty"`
+	Domains  []*DomainInfo `protobuf:"bytes,6,rep,name=domains,proto3" json:"domains,omitempty"`
+	// set on StreamLookup replies to failed requests
+	Error                string   `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IPReply) Reset()         { *m = IPReply{} }
+func (m *IPReply) String() string { return proto.CompactTextString(m) }
+func (*IPReply) ProtoMessage()    {}
+func (*IPReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_315ae8d1d23f45e4, []int{2}
+}
+
+func (m *IPReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IPReply.Unmarshal(m, b)
+}
+func (m *IPReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IPReply.Marshal(b, m, deterministic)
+}
+func (m *IPReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IPReply.Merge(m, src)
+}
+func (m *IPReply) XXX_Size() int {
+	return xxx_messageInfo_IPReply.Size(m)
+}
+func (m *IPReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_IPReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IPReply proto.InternalMessageInfo
+
+func (m *IPReply) GetIp() string {
+	if m != nil {
+		return m.Ip
+	}
+	return ""
+}
+
+func (m *IPReply) GetFound() bool {
+	if m != nil {
+		return m.Found
+	}
+	return false
+}
+
+func (m *IPReply) GetBlack() bool {
+	if m != nil {
+		return m.Black
+	}
+	return false
+}
+
+func (m *IPReply) GetAttacker() bool {
+	if m != nil {
+		return m.Attacker
+	}
+	return false
+}
+
+func (m *IPReply) GetShared() bool {
+	if m != nil {
+		return m.Shared
+	}
+	return false
+}
+
+func (m *IPReply) GetDomains() []*DomainInfo {
+	if m != nil {
+		return m.Domains
+	}
+	return nil
+}
+
+func (m *IPReply) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type DomainRequest struct {
+	Tenant               string   `protobuf:"bytes,1,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Domain               string   `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DomainRequest) Reset()         { *m = DomainRequest{} }
+func (m *DomainRequest) String() string { return proto.CompactTextString(m) }
+func (*DomainRequest) ProtoMessage()    {}
+func (*DomainRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_315ae8d1d23f45e4, []int{3}
+}
+
+func (m *DomainRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DomainRequest.Unmarshal(m, b)
+}
+func (m *DomainRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DomainRequest.Marshal(b, m, deterministic)
+}
+func (m *DomainRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DomainRequest.Merge(m, src)
+}
+func (m *DomainRequest) XXX_Size() int {
+	return xxx_messageInfo_DomainRequest.Size(m)
+}
+func (m *DomainRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DomainRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DomainRequest proto.InternalMessageInfo
+
+func (m *DomainRequest) GetTenant() string {
+	if m != nil {
+		return m.Tenant
+	}
+	return ""
+}
+
+func (m *DomainRequest) GetDomain() string {
+	if m != nil {
+		return m.Domain
+	}
+	return ""
+}
+
+type DomainIP struct {
+	Ip                   string      `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Info                 *DomainInfo `protobuf:"bytes,2,opt,name=info,proto3" json:"info,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *DomainIP) Reset()         { *m = DomainIP{} }
+func (m *DomainIP) String() string { return proto.CompactTextString(m) }
+func (*DomainIP) ProtoMessage()    {}
+func (*DomainIP) Descriptor() ([]byte, []int) {
+	return fileDescriptor_315ae8d1d23f45e4, []int{4}
+}
+
+func (m *DomainIP) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DomainIP.Unmarshal(m, b)
+}
+func (m *DomainIP) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DomainIP.Marshal(b, m, deterministic)
+}
+func (m *DomainIP) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DomainIP.Merge(m, src)
+}
+func (m *DomainIP) XXX_Size() int {
+	return xxx_messageInfo_DomainIP.Size(m)
+}
+func (m *DomainIP) XXX_DiscardUnknown() {
+	xxx_messageInfo_DomainIP.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DomainIP proto.InternalMessageInfo
+
+func (m *DomainIP) GetIp() string {
+	if m != nil {
+		return m.Ip
+	}
+	return ""
+}
+
+func (m *DomainIP) GetInfo() *DomainInfo {
+	if m != nil {
+		return m.Info
+	}
+	return nil
+}
+
+type DomainReply struct {
+	Domain               string      `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	Ips                  []*DomainIP `protobuf:"bytes,2,rep,name=ips,proto3" json:"ips,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *DomainReply) Reset()         { *m = DomainReply{} }
+func (m *DomainReply) String() string { return proto.CompactTextString(m) }
+func (*DomainReply) ProtoMessage()    {}
+func (*DomainReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_315ae8d1d23f45e4, []int{5}
+}
+
+func (m *DomainReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DomainReply.Unmarshal(m, b)
+}
+func (m *DomainReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DomainReply.Marshal(b, m, deterministic)
+}
+func (m *DomainReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DomainReply.Merge(m, src)
+}
+func (m *DomainReply) XXX_Size() int {
+	return xxx_messageInfo_DomainReply.Size(m)
+}
+func (m *DomainReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_DomainReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DomainReply proto.InternalMessageInfo
+
+func (m *DomainReply) GetDomain() string {
+	if m != nil {
+		return m.Domain
+	}
+	return ""
+}
+
+func (m *DomainReply) GetIps() []*DomainIP {
+	if m != nil {
+		return m.Ips
+	}
+	return nil
+}
+
+type SubscribeRequest struct {
+	Tenant               string   `protobuf:"bytes,1,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()    {}
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_315ae8d1d23f45e4, []int{6}
+}
+
+func (m *SubscribeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SubscribeRequest.Unmarshal(m, b)
+}
+func (m *SubscribeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SubscribeRequest.Marshal(b, m, deterministic)
+}
+func (m *SubscribeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SubscribeRequest.Merge(m, src)
+}
+func (m *SubscribeRequest) XXX_Size() int {
+	return xxx_messageInfo_SubscribeRequest.Size(m)
+}
+func (m *SubscribeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SubscribeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SubscribeRequest proto.InternalMessageInfo
+
+func (m *SubscribeRequest) GetTenant() string {
+	if m != nil {
+		return m.Tenant
+	}
+	return ""
+}
+
+type Mapping struct {
+	Ip     string `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Domain string `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	// the ip wasn't known before
+	NewIp                bool     `protobuf:"varint,3,opt,name=new_ip,json=newIp,proto3" json:"new_ip,omitempty"`
+	Source               string   `protobuf:"bytes,4,opt,name=source,proto3" json:"source,omitempty"`
+	Sensor               string   `protobuf:"bytes,5,opt,name=sensor,proto3" json:"sensor,omitempty"`
+	Wlid                 int32    `protobuf:"varint,6,opt,name=wlid,proto3" json:"wlid,omitempty"`
+	Time                 int64    `protobuf:"varint,7,opt,name=time,proto3" json:"time,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Mapping) Reset()         { *m = Mapping{} }
+func (m *Mapping) String() string { return proto.CompactTextString(m) }
+func (*Mapping) ProtoMessage()    {}
+func (*Mapping) Descriptor() ([]byte, []int) {
+	return fileDescriptor_315ae8d1d23f45e4, []int{7}
+}
+
+func (m *Mapping) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Mapping.Unmarshal(m, b)
+}
+func (m *Mapping) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Mapping.Marshal(b, m, deterministic)
+}
+func (m *Mapping) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Mapping.Merge(m, src)
+}
+func (m *Mapping) XXX_Size() int {
+	return xxx_messageInfo_Mapping.Size(m)
+}
+func (m *Mapping) XXX_DiscardUnknown() {
+	xxx_messageInfo_Mapping.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Mapping proto.InternalMessageInfo
+
+func (m *Mapping) GetIp() string {
+	if m != nil {
+		return m.Ip
+	}
+	return ""
+}
+
+func (m *Mapping) GetDomain() string {
+	if m != nil {
+		return m.Domain
+	}
+	return ""
+}
+
+func (m *Mapping) GetNewIp() bool {
+	if m != nil {
+		return m.NewIp
+	}
+	return false
+}
+
+func (m *Mapping) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *Mapping) GetSensor() string {
+	if m != nil {
+		return m.Sensor
+	}
+	return ""
+}
+
+func (m *Mapping) GetWlid() int32 {
+	if m != nil {
+		return m.Wlid
+	}
+	return 0
+}
+
+func (m *Mapping) GetTime() int64 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*IPRequest)(nil), "revdns.IPRequest")
+	proto.RegisterType((*DomainInfo)(nil), "revdns.DomainInfo")
+	proto.RegisterType((*IPReply)(nil), "revdns.IPReply")
+	proto.RegisterType((*DomainRequest)(nil), "revdns.DomainRequest")
+	proto.RegisterType((*DomainIP)(nil), "revdns.DomainIP")
+	proto.RegisterType((*DomainReply)(nil), "revdns.DomainReply")
+	proto.RegisterType((*SubscribeRequest)(nil), "revdns.SubscribeRequest")
+	proto.RegisterType((*Mapping)(nil), "revdns.Mapping")
+}
+
+func init() { proto.RegisterFile("revdns.proto", fileDescriptor_315ae8d1d23f45e4) }
+
+var fileDescriptor_315ae8d1d23f45e4 = []byte{
+	// 552 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x54, 0xdd, 0x6a, 0x1b, 0x3d,
+	0x10, 0x45, 0xde, 0x1f, 0xef, 0x4e, 0xf2, 0x7d, 0x4d, 0xd5, 0x34, 0x88, 0x94, 0x82, 0xd9, 0x8b,
+	0x62, 0x4a, 0x31, 0x21, 0x85, 0x52, 0x7a, 0x53, 0x08, 0xbe, 0x31, 0xb4, 0xc5, 0xc8, 0x77, 0xbd,
+	0x09, 0x6b, 0x5b, 0x6e, 0x17, 0xdb, 0x92, 0x2a, 0x69, 0x63, 0xfa, 0x36, 0x7d, 0x8e, 0x42, 0x5f,
+	0xa7, 0xcf, 0x51, 0x76, 0xa4, 0xb5, 0x1d, 0xa7, 0x21, 0xbd, 0xd3, 0x39, 0x33, 0xda, 0x33, 0x73,
+	0x46, 0xb3, 0x70, 0x6c, 0xc4, 0xcd, 0x5c, 0xda, 0x81, 0x36, 0xca, 0x29, 0x9a, 0x7a, 0x54, 0xd4,
+	0x90, 0x8f, 0xc6, 0x5c, 0x7c, 0xab, 0x85, 0x75, 0xf4, 0x0c, 0x52, 0x27, 0x64, 0x29, 0x1d, 0x23,
+	0x3d, 0xd2, 0xcf, 0x79, 0x40, 0xf4, 0x7f, 0xe8, 0x54, 0x9a, 0x75, 0x90, 0xeb, 0x54, 0xba, 0xc9,
+	0xb3, 0xaa, 0x36, 0x33, 0xc1, 0x22, 0x9f, 0xe7, 0x11, 0xf2, 0x42, 0x5a, 0x65, 0x58, 0x1c, 0x78,
+	0x44, 0xf4, 0x04, 0x22, 0xa7, 0x34, 0x4b, 0x7a, 0xa4, 0x9f, 0xf0, 0xe6, 0x58, 0xfc, 0x24, 0x00,
+	0x43, 0xb5, 0x2e, 0x2b, 0x39, 0x92, 0x0b, 0x45, 0x29, 0xc4, 0xb2, 0x5c, 0x8b, 0x20, 0x8b, 0xe7,
+	0x86, 0xdb, 0xac, 0xaa, 0x39, 0xca, 0x26, 0x1c, 0xcf, 0x94, 0x41, 0xd7, 0x4b, 0x59, 0x16, 0xf5,
+	0xa2, 0x7e, 0xce, 0x5b, 0x88, 0x11, 0x14, 0xb3, 0x2c, 0x0e, 0x11, 0x0f, 0xe9, 0x29, 0x24, 0x33,
+	0x55, 0x4b, 0x87, 0xf2, 0x11, 0xf7, 0x80, 0x3e, 0x07, 0x58, 0x54, 0xc6, 0xba, 0x6b, 0x2b, 0x84,
+	0x64, 0x29, 0x86, 0x72, 0x64, 0x26, 0x42, 0x48, 0xfa, 0x0c, 0xf2, 0x55, 0xd9, 0x46, 0xbb, 0x18,
+	0xcd, 0x56, 0xa5, 0x0f, 0x16, 0xbf, 0x08, 0x74, 0x1b, 0xd3, 0xf4, 0xea, 0x7b, 0xb0, 0x86, 0x6c,
+	0xad, 0x39, 0x85, 0x64, 0xa1, 0x6a, 0xe9, 0xcb, 0xce, 0xb8, 0x07, 0x0d, 0x3b, 0x5d, 0x95, 0xb3,
+	0x25, 0xfa, 0x95, 0x71, 0x0f, 0xe8, 0x39, 0x64, 0xa5, 0x73, 0xe5, 0x6c, 0x29, 0xbc, 0x61, 0x19,
+	0xdf, 0x62, 0xb4, 0xf2, 0x6b, 0x69, 0xc4, 0x1c, 0xcb, 0xce, 0x78, 0x40, 0xf4, 0x15, 0x74, 0xe7,
+	0xe8, 0x9b, 0x65, 0x69, 0x2f, 0xea, 0x1f, 0x5d, 0xd2, 0x41, 0x98, 0xeb, 0xce, 0x4e, 0xde, 0xa6,
+	0x34, 0xba, 0xc2, 0x18, 0x65, 0xb0, 0x85, 0x9c, 0x7b, 0x50, 0xbc, 0x87, 0xff, 0x7c, 0xf2, 0x43,
+	0x73, 0x3f, 0x83, 0xd4, 0x7f, 0x29, 0xcc, 0x3e, 0xa0, 0xe2, 0x0a, 0xb2, 0xa0, 0x36, 0xbe, 0x63,
+	0xc0, 0x0b, 0x88, 0x2b, 0xb9, 0x50, 0x78, 0xe3, 0xef, 0xd5, 0x61, 0xbc, 0x18, 0xc1, 0x51, 0x5b,
+	0x44, 0xe3, 0xe3, 0x4e, 0x8a, 0xec, 0x4b, 0xd1, 0x02, 0xa2, 0x4a, 0x5b, 0xd6, 0xc1, 0x5e, 0x4f,
+	0x0e, 0xbe, 0x36, 0xe6, 0x4d, 0xb0, 0x78, 0x09, 0x27, 0x93, 0x7a, 0x6a, 0x67, 0xa6, 0x9a, 0x8a,
+	0x07, 0x5a, 0x2a, 0x7e, 0x10, 0xe8, 0x7e, 0x2c, 0xb5, 0xae, 0xe4, 0x97, 0x3b, 0xa5, 0xdf, 0xd3,
+	0x2e, 0x7d, 0x0a, 0xa9, 0x14, 0x9b, 0xeb, 0x4a, 0xb7, 0xe3, 0x93, 0x62, 0x33, 0xda, 0xdf, 0x82,
+	0xf8, 0x9e, 0x2d, 0x48, 0x6e, 0x6d, 0x41, 0xfb, 0xa0, 0xd3, 0xbd, 0x07, 0x4d, 0x21, 0x76, 0xd5,
+	0x5a, 0x84, 0x27, 0x86, 0xe7, 0xcb, 0xdf, 0x04, 0x52, 0x2e, 0x6e, 0x86, 0x9f, 0x26, 0x74, 0x00,
+	0xd9, 0x07, 0xa5, 0x96, 0xb5, 0x1e, 0x8d, 0xe9, 0xe3, 0xb6, 0xf9, 0xed, 0xbe, 0x9e, 0x3f, 0xda,
+	0xa7, 0x1a, 0x17, 0xdf, 0xc1, 0xb1, 0xcf, 0x1f, 0x86, 0xca, 0x6f, 0x1b, 0xd6, 0xde, 0x7b, 0x72,
+	0x48, 0x37, 0x77, 0xdf, 0xc0, 0xf1, 0xc4, 0x19, 0x51, 0xae, 0xfd, 0x17, 0xfe, 0x45, 0xaf, 0x4f,
+	0x2e, 0x08, 0x7d, 0x0b, 0xf9, 0xd6, 0x7d, 0xca, 0xda, 0x8c, 0xc3, 0x81, 0xec, 0xee, 0x06, 0xf7,
+	0x2f, 0xc8, 0x15, 0x7c, 0xce, 0x3c, 0xa7, 0xa7, 0xd3, 0x14, 0x7f, 0x4b, 0xaf, 0xff, 0x0c, 0x00,
+	0x27, 0xf7, 0x94, 0x6b, 0xa6, 0x04, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// RevDNSClient is the client API for RevDNS service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type RevDNSClient interface {
+	// LookupIP returns the domains seen for an ip
+	LookupIP(ctx context.Context, in *IPRequest, opts ...grpc.CallOption) (*IPReply, error)
+	// LookupDomain returns the ips a domain was seen on
+	LookupDomain(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*DomainReply, error)
+	// StreamLookup answers a stream of ip lookups, replies are sent in
+	// request order
+	StreamLookup(ctx context.Context, opts ...grpc.CallOption) (RevDNS_StreamLookupClient, error)
+	// Subscribe streams newly observed ip/domain pairs
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (RevDNS_SubscribeClient, error)
+}
+
+type revDNSClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewRevDNSClient(cc *grpc.ClientConn) RevDNSClient {
+	return &revDNSClient{cc}
+}
+
+func (c *revDNSClient) LookupIP(ctx context.Context, in *IPRequest, opts ...grpc.CallOption) (*IPReply, error) {
+	out := new(IPReply)
+	err := c.cc.Invoke(ctx, "/revdns.RevDNS/LookupIP", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *revDNSClient) LookupDomain(ctx context.Context, in *DomainRequest, opts ...grpc.CallOption) (*DomainReply, error) {
+	out := new(DomainReply)
+	err := c.cc.Invoke(ctx, "/revdns.RevDNS/LookupDomain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *revDNSClient) StreamLookup(ctx context.Context, opts ...grpc.CallOption) (RevDNS_StreamLookupClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_RevDNS_serviceDesc.Streams[0], "/revdns.RevDNS/StreamLookup", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &revDNSStreamLookupClient{stream}
+	return x, nil
+}
+
+type RevDNS_StreamLookupClient interface {
+	Send(*IPRequest) error
+	Recv() (*IPReply, error)
+	grpc.ClientStream
+}
+
+type revDNSStreamLookupClient struct {
+	grpc.ClientStream
+}
+
+func (x *revDNSStreamLookupClient) Send(m *IPRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *revDNSStreamLookupClient) Recv() (*IPReply, error) {
+	m := new(IPReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *revDNSClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (RevDNS_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_RevDNS_serviceDesc.Streams[1], "/revdns.RevDNS/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &revDNSSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RevDNS_SubscribeClient interface {
+	Recv() (*Mapping, error)
+	grpc.ClientStream
+}
+
+type revDNSSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *revDNSSubscribeClient) Recv() (*Mapping, error) {
+	m := new(Mapping)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RevDNSServer is the server API for RevDNS service.
+type RevDNSServer interface {
+	// LookupIP returns the domains seen for an ip
+	LookupIP(context.Context, *IPRequest) (*IPReply, error)
+	// LookupDomain returns the ips a domain was seen on
+	LookupDomain(context.Context, *DomainRequest) (*DomainReply, error)
+	// StreamLookup answers a stream of ip lookups, replies are sent in
+	// request order
+	StreamLookup(RevDNS_StreamLookupServer) error
+	// Subscribe streams newly observed ip/domain pairs
+	Subscribe(*SubscribeRequest, RevDNS_SubscribeServer) error
+}
+
+func RegisterRevDNSServer(s *grpc.Server, srv RevDNSServer) {
+	s.RegisterService(&_RevDNS_serviceDesc, srv)
+}
+
+func _RevDNS_LookupIP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IPRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RevDNSServer).LookupIP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/revdns.RevDNS/LookupIP",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RevDNSServer).LookupIP(ctx, req.(*IPRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RevDNS_LookupDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RevDNSServer).LookupDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/revdns.RevDNS/LookupDomain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RevDNSServer).LookupDomain(ctx, req.(*DomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RevDNS_StreamLookup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RevDNSServer).StreamLookup(&revDNSStreamLookupServer{stream})
+}
+
+type RevDNS_StreamLookupServer interface {
+	Send(*IPReply) error
+	Recv() (*IPRequest, error)
+	grpc.ServerStream
+}
+
+type revDNSStreamLookupServer struct {
+	grpc.ServerStream
+}
+
+func (x *revDNSStreamLookupServer) Send(m *IPReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *revDNSStreamLookupServer) Recv() (*IPRequest, error) {
+	m := new(IPRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _RevDNS_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RevDNSServer).Subscribe(m, &revDNSSubscribeServer{stream})
+}
+
+type RevDNS_SubscribeServer interface {
+	Send(*Mapping) error
+	grpc.ServerStream
+}
+
+type revDNSSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *revDNSSubscribeServer) Send(m *Mapping) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _RevDNS_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "revdns.RevDNS",
+	HandlerType: (*RevDNSServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "LookupIP",
+			Handler:    _RevDNS_LookupIP_Handler,
+		},
+		{
+			MethodName: "LookupDomain",
+			Handler:    _RevDNS_LookupDomain_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamLookup",
+			Handler:       _RevDNS_StreamLookup_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Subscribe",
+			Handler:       _RevDNS_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "revdns.proto",
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gviz/revDNS/internal/audit"
+	"github.com/gviz/revDNS/internal/revconfig"
+)
+
+//auditEntry one api request in the audit log
+type auditEntry struct {
+	Time     string `json:"time"`
+	Identity string `json:"identity"`
+	Auth     string `json:"auth,omitempty"`
+	Tenant   string `json:"tenant"`
+	Client   string `json:"client"`
+	Method   string `json:"method"`
+	Route    string `json:"route"`
+	//queried ip, domain or prefix. Bulk lookups log the number of ips
+	//and the sha256 of the ips joined by newlines, not the list.
+	Query      string `json:"query,omitempty"`
+	QueryCount int    `json:"query_count,omitempty"`
+	QueryHash  string `json:"query_hash,omitempty"`
+	Params     string `json:"params,omitempty"`
+	//ip/domain pairs returned
+	Results   int     `json:"results"`
+	Status    int     `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+
+	lock sync.Mutex
+}
+
+//newAuditLogger opens the configured audit log, nil when disabled
+func newAuditLogger(conf revconfig.AuditConfig) (*audit.Logger, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	switch conf.Output {
+	case "file":
+		f, err := audit.OpenFile(conf.File, int64(conf.MaxSize)<<20, conf.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		return audit.New(f), nil
+	case "syslog":
+		return audit.NewSyslog(conf.SyslogNetwork, conf.SyslogAddress, conf.SyslogTag)
+	}
+	return nil, fmt.Errorf("unknown audit output %q", conf.Output)
+}
+
+func clientHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+//auditQuery the value a request asks about
+func auditQuery(req *http.Request) string {
+	vars := mux.Vars(req)
+	switch {
+	case vars["bits"] != "":
+		return vars["ip"] + "/" + vars["bits"]
+	case vars["ip"] != "" && vars["domain"] != "":
+		return vars["ip"] + " " + vars["domain"]
+	case vars["ip"] != "":
+		return vars["ip"]
+	case vars["domain"] != "":
+		return vars["domain"]
+	case vars["value"] != "":
+		return vars["value"]
+	}
+	return vars["key"]
+}
+
+//auditResults adds n returned pairs to the request's audit entry
+func auditResults(req *http.Request, n int) {
+	if e, ok := req.Context().Value(auditKey).(*auditEntry); ok {
+		e.lock.Lock()
+		e.Results += n
+		e.lock.Unlock()
+	}
+}
+
+//auditQueries records the count and hash of the ips of a bulk request
+func auditQueries(req *http.Request, ips []string) {
+	if e, ok := req.Context().Value(auditKey).(*auditEntry); ok {
+		sum := sha256.Sum256([]byte(strings.Join(ips, "\n")))
+		e.lock.Lock()
+		e.QueryCount, e.QueryHash = len(ips), hex.EncodeToString(sum[:])
+		e.lock.Unlock()
+	}
+}
+
+//logAudit writes e, failures are logged and don't fail requests
+func (r *revDns) logAudit(e *auditEntry) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := r.auditLog.Log(e); err != nil {
+		log.Println("Error writing audit log:", err)
+	}
+}
+
+//auditRequests logs authorized requests to route with their caller,
+//query, result count and latency
+func (r *revDns) auditRequests(route string, next http.Handler) http.Handler {
+	if r.auditLog == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		e := &auditEntry{
+			Time:   start.UTC().Format(time.RFC3339Nano),
+			Tenant: tenantOf(req),
+			Client: clientHost(req.RemoteAddr),
+			Method: req.Method,
+			Route:  route,
+			Query:  auditQuery(req),
+			Params: req.URL.RawQuery,
+		}
+		if id := identityOf(req); id != nil {
+			e.Identity, e.Auth = id.Name, id.Method
+		}
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, req.WithContext(context.WithValue(req.Context(), auditKey, e)))
+		e.Status = sw.code
+		e.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+		r.logAudit(e)
+	})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+//flush streamed bulk results every bulkFlush records
+const bulkFlush = 100
+
+//recordWriter streams records as NDJSON, flushing every bulkFlush
+type recordWriter struct {
+	w     http.ResponseWriter
+	enc   *json.Encoder
+	count int
+}
+
+func newRecordWriter(w http.ResponseWriter) *recordWriter {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	return &recordWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+func (rw *recordWriter) close() error {
+	return nil
+}
+
+func (rw *recordWriter) write(rec revdb.Record) error {
+	if err := rw.enc.Encode(rec); err != nil {
+		return err
+	}
+	rw.count++
+	if f, ok := rw.w.(http.Flusher); ok && rw.count%bulkFlush == 0 {
+		f.Flush()
+	}
+	return nil
+}
+
+//parseBulk reads a JSON array or NDJSON of ips. NDJSON lines may be
+//"ip" strings, {"ip": ...} objects or bare ips. Ips are returned in
+//canonical form, as single lookups query them.
+func parseBulk(body io.Reader, max int) ([]string, error) {
+	br := bufio.NewReader(body)
+	var ips []string
+	add := func(ip string) error {
+		ip = strings.TrimSpace(ip)
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return fmt.Errorf("invalid ip %q", ip)
+		}
+		if len(ips) == max {
+			return errBulkTooLarge
+		}
+		ips = append(ips, addr.String())
+		return nil
+	}
+
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return ips, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first == '[' {
+		dec := json.NewDecoder(br)
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			var ip string
+			if err := dec.Decode(&ip); err != nil {
+				return nil, err
+			}
+			if err := add(ip); err != nil {
+				return nil, err
+			}
+		}
+		return ips, nil
+	}
+
+	sc := bufio.NewScanner(br)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+			continue
+		case line[0] == '{':
+			var rec struct {
+				IP string `json:"ip"`
+			}
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				return nil, err
+			}
+			line = rec.IP
+		case line[0] == '"':
+			if err := json.Unmarshal([]byte(line), &line); err != nil {
+				return nil, err
+			}
+		}
+		if err := add(line); err != nil {
+			return nil, err
+		}
+	}
+	return ips, sc.Err()
+}
+
+var errBulkTooLarge = fmt.Errorf("too many ips")
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+//bulkHandler looks up many ips in one read transaction and streams
+//back one NDJSON record per ip
+func (r *revDns) bulkHandler(w http.ResponseWriter, req *http.Request) {
+	filter, err := newLkupFilter(req.URL.Query())
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	format, err := negotiate(req.Header.Get("Accept"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ips, err := parseBulk(req.Body, r.conf.Api.BulkMax)
+	if err == errBulkTooLarge {
+		writeError(w, newAPIError(http.StatusRequestEntityTooLarge,
+			"at most %d ips per request", r.conf.Api.BulkMax))
+		return
+	}
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+
+	auditQueries(req, ips)
+
+	db, err := r.tenantDB(tenantOf(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	br, ok := db.(revdb.BulkReader)
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotImplemented, "bulk lookups not supported"))
+		return
+	}
+
+	enc := newRecordEncoder(format, w, fmt.Sprintf("revDNS lookup of %d ips", len(ips)))
+	//records are annotated and written in batches of bulkFlush
+	batch := make([]revdb.Record, 0, bulkFlush)
+	flush := func() error {
+		if err := annotateAll(db, batch); err != nil {
+			log.Println("Error reading annotations:", err)
+		}
+		for _, rec := range batch {
+			if err := enc.write(rec); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	err = br.ReadDBMulti(ips, func(ip string, val revdb.DnsVal) error {
+		if err := req.Context().Err(); err != nil {
+			return err
+		}
+		if val.Domains == nil {
+			val = *revdb.NewDnsVal()
+		}
+		val = filter.apply(val)
+		auditResults(req, len(val.Domains))
+		if batch = append(batch, revdb.Record{IP: ip, DnsVal: val}); len(batch) == bulkFlush {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Println("Bulk lookup failed:", err)
+		if format == mimeSTIX || format == mimeMISP {
+			//nothing was written yet
+			writeError(w, err)
+			return
+		}
+	}
+	enc.close()
+}
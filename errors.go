@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+//apiError error carrying the http status it maps to
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(code int, format string, args ...interface{}) *apiError {
+	return &apiError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+var (
+	errDBUnavailable = newAPIError(http.StatusServiceUnavailable, "database not open")
+	errNotFound      = newAPIError(http.StatusNotFound, "not found")
+	errTimeout       = newAPIError(http.StatusGatewayTimeout, "request timed out")
+)
+
+//writeError sends err in the api's error envelope,
+//{"error": {"code": 404, "message": "not found"}}
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = newAPIError(http.StatusInternalServerError, "%s", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(map[string]*apiError{"error": e})
+}
+
+//badRequest sends a 400 error envelope
+func badRequest(w http.ResponseWriter, format string, args ...interface{}) {
+	writeError(w, newAPIError(http.StatusBadRequest, format, args...))
+}
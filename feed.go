@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+const (
+	//feedBuffer new mappings queued per subscriber before it is dropped
+	feedBuffer = 1024
+	//feedKeepAlive interval of comments keeping idle event streams open
+	feedKeepAlive = 15 * time.Second
+	maxFeedNets   = 64
+)
+
+//subscriber receives the new mappings of a tenant
+type subscriber struct {
+	tenant string
+	match  func(m revdb.Mapping) bool
+	c      chan revdb.Mapping
+	//closed when the subscriber is dropped for falling behind
+	dropped chan struct{}
+}
+
+//feed fans new mappings out to subscribers. Publishing never blocks
+//the writers, subscribers that don't keep up are dropped.
+type feed struct {
+	lock sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+func newFeed() *feed {
+	return &feed{subs: make(map[*subscriber]struct{})}
+}
+
+//subscribe registers for the tenant's mappings accepted by match,
+//a nil match accepts all
+func (f *feed) subscribe(tenant string, match func(m revdb.Mapping) bool) *subscriber {
+	s := &subscriber{
+		tenant:  tenant,
+		match:   match,
+		c:       make(chan revdb.Mapping, feedBuffer),
+		dropped: make(chan struct{}),
+	}
+	f.lock.Lock()
+	f.subs[s] = struct{}{}
+	f.lock.Unlock()
+	return s
+}
+
+func (f *feed) unsubscribe(s *subscriber) {
+	f.lock.Lock()
+	delete(f.subs, s)
+	f.lock.Unlock()
+}
+
+func (f *feed) publish(tenant string, m revdb.Mapping) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for s := range f.subs {
+		if s.tenant != tenant || (s.match != nil && !s.match(m)) {
+			continue
+		}
+		select {
+		case s.c <- m:
+		default:
+			log.Printf("Dropping slow %s feed subscriber", tenant)
+			delete(f.subs, s)
+			close(s.dropped)
+		}
+	}
+}
+
+//feedFilter selects the mappings sent to a live feed client
+type feedFilter struct {
+	nets   []*net.IPNet
+	suffix string
+	source string
+	newIP  bool
+	rank   rankFilter
+}
+
+func newFeedFilter(q url.Values) (feedFilter, error) {
+	f := feedFilter{
+		suffix: strings.Trim(q.Get("suffix"), "."),
+		source: q.Get("source"),
+		newIP:  q.Get("new_ip") == "true",
+	}
+	switch f.source {
+	case "", revdb.SourceDNS, revdb.SourceSSL, revdb.SourceHTTP:
+	default:
+		return f, fmt.Errorf("unknown source %q", f.source)
+	}
+	if len(q["cidr"]) > maxFeedNets {
+		return f, fmt.Errorf("at most %d cidrs", maxFeedNets)
+	}
+	for _, cidr := range q["cidr"] {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return f, fmt.Errorf("invalid cidr %q", cidr)
+		}
+		f.nets = append(f.nets, n)
+	}
+	var err error
+	f.rank, err = newRankFilter(q)
+	return f, err
+}
+
+func (f feedFilter) match(m revdb.Mapping) bool {
+	if f.newIP && !m.NewIP {
+		return false
+	}
+	if f.source != "" && m.Source != f.source {
+		return false
+	}
+	if f.suffix != "" && !revdb.MatchSuffix(m.Domain, f.suffix) {
+		return false
+	}
+	if !f.rank.match(m.WlId) {
+		return false
+	}
+	if len(f.nets) > 0 {
+		ip := net.ParseIP(m.IP)
+		for _, n := range f.nets {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+//feedHandler streams new ip/domain pairs as server-sent events until
+//the client goes away. Clients that fall feedBuffer events behind get
+//a dropped event and are disconnected.
+func (r *revDns) feedHandler(w http.ResponseWriter, req *http.Request) {
+	filter, err := newFeedFilter(req.URL.Query())
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotImplemented, "streaming not supported"))
+		return
+	}
+	sub := r.feed.subscribe(tenantOf(req), filter.match)
+	defer r.feed.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(feedKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case m := <-sub.c:
+			data, _ := json.Marshal(m)
+			if _, err := fmt.Fprintf(w, "event: mapping\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			//send what's queued in one go
+			n := len(sub.c)
+			for i := 0; i < n; i++ {
+				data, _ = json.Marshal(<-sub.c)
+				fmt.Fprintf(w, "event: mapping\ndata: %s\n\n", data)
+			}
+			auditResults(req, n+1)
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-sub.dropped:
+			fmt.Fprint(w, "event: dropped\ndata: {\"error\":\"client fell behind\"}\n\n")
+			flusher.Flush()
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+//Response formats picked by the Accept header
+const (
+	mimeJSON = "application/json"
+	mimeCSV  = "text/csv"
+	mimeSTIX = "application/stix+json"
+	mimeMISP = "application/vnd.misp+json"
+)
+
+//stixNamespace the STIX 2.1 namespace of deterministic SCO ids
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c,
+	0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+var errNotAcceptable = newAPIError(http.StatusNotAcceptable,
+	"supported formats are %s, %s, %s and %s", mimeJSON, mimeCSV, mimeSTIX, mimeMISP)
+
+//negotiate picks the format of the highest quality the client accepts,
+//json when it doesn't say
+func negotiate(accept string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return mimeJSON, nil
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var format string
+		switch typ {
+		case mimeJSON, "application/x-ndjson", "application/*", "*/*":
+			format = mimeJSON
+		case mimeCSV, "text/*":
+			format = mimeCSV
+		case mimeSTIX:
+			format = mimeSTIX
+		case mimeMISP:
+			format = mimeMISP
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best == "" {
+		return "", errNotAcceptable
+	}
+	return best, nil
+}
+
+//recordEncoder writes lookup results in one of the response formats.
+//Formats that are a single document are written on close.
+type recordEncoder interface {
+	write(rec revdb.Record) error
+	close() error
+}
+
+//newRecordEncoder returns the encoder of format, title names the
+//lookup in formats that describe it
+func newRecordEncoder(format string, w http.ResponseWriter, title string) recordEncoder {
+	switch format {
+	case mimeCSV:
+		return newCSVEncoder(w)
+	case mimeSTIX:
+		return newSTIXEncoder(w)
+	case mimeMISP:
+		return newMISPEncoder(w, title)
+	}
+	return newRecordWriter(w)
+}
+
+//sortedDomains returns the names of val in a stable order
+func sortedDomains(val revdb.DnsVal) []string {
+	names := make([]string, 0, len(val.Domains))
+	for name := range val.Domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//csvEncoder writes a row per ip/domain pair, ips without domains get
+//a row with an empty domain
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+var csvHeader = []string{"ip", "domain", "wlid", "sources", "sensors", "count", "first_seen", "last_seen"}
+
+func newCSVEncoder(w http.ResponseWriter) *csvEncoder {
+	w.Header().Set("Content-Type", mimeCSV+"; charset=utf-8")
+	c := &csvEncoder{w: csv.NewWriter(w)}
+	c.w.Write(csvHeader)
+	return c
+}
+
+func (c *csvEncoder) write(rec revdb.Record) error {
+	if len(rec.Domains) == 0 {
+		c.w.Write([]string{rec.IP, "", "", "", "", "", "", ""})
+	}
+	for _, name := range sortedDomains(rec.DnsVal) {
+		info := rec.Domains[name]
+		c.w.Write([]string{
+			rec.IP,
+			name,
+			strconv.Itoa(info.WlId),
+			strings.Join(info.Sources, ";"),
+			strings.Join(info.Sensors, ";"),
+			strconv.Itoa(info.Count),
+			strconv.FormatInt(info.FirstSeen, 10),
+			strconv.FormatInt(info.LastSeen, 10),
+		})
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvEncoder) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+//uuid5 returns the name based uuid of name in namespace, RFC 4122
+func uuid5(namespace [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+//uuid4 returns a random uuid
+func uuid4() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+//stixTime formats unix seconds as a STIX timestamp
+func stixTime(secs int64) string {
+	return time.Unix(secs, 0).UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+//stixEncoder collects observables and their resolves-to relationships
+//into a STIX 2.1 bundle
+type stixEncoder struct {
+	w       http.ResponseWriter
+	now     string
+	seen    map[string]bool
+	objects []map[string]interface{}
+}
+
+func newSTIXEncoder(w http.ResponseWriter) *stixEncoder {
+	return &stixEncoder{
+		w:    w,
+		now:  stixTime(time.Now().Unix()),
+		seen: make(map[string]bool),
+	}
+}
+
+//observable adds the SCO of value once and returns its id, ids are
+//derived from the value as the spec asks
+func (s *stixEncoder) observable(typ string, value string) string {
+	key, _ := json.Marshal(map[string]string{"value": value})
+	id := typ + "--" + uuid5(stixNamespace, string(key))
+	if !s.seen[id] {
+		s.seen[id] = true
+		s.objects = append(s.objects, map[string]interface{}{
+			"type":         typ,
+			"spec_version": "2.1",
+			"id":           id,
+			"value":        value,
+		})
+	}
+	return id
+}
+
+func (s *stixEncoder) write(rec revdb.Record) error {
+	typ := "ipv6-addr"
+	if addr := net.ParseIP(rec.IP); addr != nil && addr.To4() != nil {
+		typ = "ipv4-addr"
+	}
+	ipID := s.observable(typ, rec.IP)
+	for _, name := range sortedDomains(rec.DnsVal) {
+		info := rec.Domains[name]
+		domainID := s.observable("domain-name", name)
+		rel := map[string]interface{}{
+			"type":              "relationship",
+			"spec_version":      "2.1",
+			"id":                "relationship--" + uuid5(stixNamespace, domainID+ipID),
+			"created":           s.now,
+			"modified":          s.now,
+			"relationship_type": "resolves-to",
+			"source_ref":        domainID,
+			"target_ref":        ipID,
+		}
+		if info.FirstSeen != 0 && info.LastSeen > info.FirstSeen {
+			rel["start_time"] = stixTime(info.FirstSeen)
+			rel["stop_time"] = stixTime(info.LastSeen)
+		}
+		s.objects = append(s.objects, rel)
+	}
+	return nil
+}
+
+func (s *stixEncoder) close() error {
+	s.w.Header().Set("Content-Type", mimeSTIX+";version=2.1")
+	return json.NewEncoder(s.w).Encode(map[string]interface{}{
+		"type":    "bundle",
+		"id":      "bundle--" + uuid4(),
+		"objects": s.objects,
+	})
+}
+
+//MISP event with a domain-ip object per pair
+type mispAttribute struct {
+	Type           string `json:"type"`
+	ObjectRelation string `json:"object_relation"`
+	Value          string `json:"value"`
+	ToIDS          bool   `json:"to_ids"`
+}
+
+type mispObject struct {
+	Name         string          `json:"name"`
+	MetaCategory string          `json:"meta-category"`
+	Attribute    []mispAttribute `json:"Attribute"`
+}
+
+type mispEvent struct {
+	UUID           string       `json:"uuid"`
+	Info           string       `json:"info"`
+	Date           string       `json:"date"`
+	Timestamp      string       `json:"timestamp"`
+	ThreatLevelID  string       `json:"threat_level_id"`
+	Analysis       string       `json:"analysis"`
+	Distribution   string       `json:"distribution"`
+	Object         []mispObject `json:"Object"`
+	AttributeCount string       `json:"attribute_count"`
+}
+
+type mispEncoder struct {
+	w     http.ResponseWriter
+	event mispEvent
+	count int
+}
+
+func newMISPEncoder(w http.ResponseWriter, title string) *mispEncoder {
+	now := time.Now().UTC()
+	return &mispEncoder{
+		w: w,
+		event: mispEvent{
+			UUID:      uuid4(),
+			Info:      title,
+			Date:      now.Format("2006-01-02"),
+			Timestamp: strconv.FormatInt(now.Unix(), 10),
+			//undefined threat level, analysis completed, your organisation only
+			ThreatLevelID: "4",
+			Analysis:      "2",
+			Distribution:  "0",
+			Object:        []mispObject{},
+		},
+	}
+}
+
+func (m *mispEncoder) write(rec revdb.Record) error {
+	for _, name := range sortedDomains(rec.DnsVal) {
+		info := rec.Domains[name]
+		attrs := []mispAttribute{
+			{Type: "domain", ObjectRelation: "domain", Value: name},
+			{Type: "ip-dst", ObjectRelation: "ip", Value: rec.IP},
+		}
+		if info.FirstSeen != 0 {
+			attrs = append(attrs,
+				mispAttribute{Type: "datetime", ObjectRelation: "first-seen", Value: time.Unix(info.FirstSeen, 0).UTC().Format(time.RFC3339)},
+				mispAttribute{Type: "datetime", ObjectRelation: "last-seen", Value: time.Unix(info.LastSeen, 0).UTC().Format(time.RFC3339)})
+		}
+		m.count += len(attrs)
+		m.event.Object = append(m.event.Object, mispObject{
+			Name:         "domain-ip",
+			MetaCategory: "network",
+			Attribute:    attrs,
+		})
+	}
+	return nil
+}
+
+func (m *mispEncoder) close() error {
+	m.event.AttributeCount = strconv.Itoa(m.count)
+	m.w.Header().Set("Content-Type", mimeMISP)
+	return json.NewEncoder(m.w).Encode(map[string]mispEvent{"Event": m.event})
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gviz/revDNS/api/revdnspb"
+	"github.com/gviz/revDNS/internal/auth"
+	"github.com/gviz/revDNS/internal/revdb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+//streamWindow lookups of a StreamLookup call in flight at once
+const streamWindow = 64
+
+//grpcServer serves api/revdns.proto, lookups take the same path
+//through dbHandler as the REST api
+type grpcServer struct {
+	r *revDns
+}
+
+func (r *revDns) grpcHandler() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", r.conf.Api.GrpcPort))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var opts []grpc.ServerOption
+	if r.tlsConf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(r.tlsConf)))
+	}
+	s := grpc.NewServer(opts...)
+	revdnspb.RegisterRevDNSServer(s, &grpcServer{r: r})
+	log.Fatal(s.Serve(lis))
+}
+
+//grpcError maps api errors to grpc status codes
+func grpcError(err error) error {
+	e, ok := err.(*apiError)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+	code := codes.Internal
+	switch e.Code {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, e.Message)
+}
+
+//grpcCall an authorized call
+type grpcCall struct {
+	tenant string
+	id     *auth.Identity
+	addr   string
+	method string
+	start  time.Time
+}
+
+//audit logs the call's query and the pairs it returned
+func (c *grpcCall) audit(r *revDns, query string, results int, err error) {
+	if r.auditLog == nil {
+		return
+	}
+	e := &auditEntry{
+		Time:      c.start.UTC().Format(time.RFC3339Nano),
+		Identity:  c.id.Name,
+		Auth:      c.id.Method,
+		Tenant:    c.tenant,
+		Client:    clientHost(c.addr),
+		Method:    "grpc",
+		Route:     c.method,
+		Query:     query,
+		Results:   results,
+		Status:    http.StatusOK,
+		LatencyMs: float64(time.Since(c.start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		e.Status = http.StatusInternalServerError
+		if ae, ok := err.(*apiError); ok {
+			e.Status = ae.Code
+		}
+	}
+	r.logAudit(e)
+}
+
+//authorize authorizes a call for the reader role on tenant. Callers
+//authenticate with the x-api-key or authorization metadata or a
+//client certificate.
+func (g *grpcServer) authorize(ctx context.Context, tenant string, method string) (*grpcCall, error) {
+	start := time.Now()
+	tenant = strings.ToLower(tenant)
+	if tenant == "" {
+		tenant = defaultTenant
+	}
+	var c auth.Credentials
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get("x-api-key"); len(keys) > 0 {
+		c.APIKey = keys[0]
+	}
+	if h := md.Get("authorization"); len(h) > 0 && strings.HasPrefix(h[0], "Bearer ") {
+		c.Bearer = strings.TrimSpace(h[0][len("Bearer "):])
+	}
+	what, addr := "grpc "+method, ""
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+		what += " from " + addr
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			c.Certs = info.State.VerifiedChains[0]
+		}
+	}
+	if wait := g.r.deniedWait(addr); wait > 0 {
+		return nil, errRateLimited(wait)
+	}
+	id, err := g.r.access(c, addr, tenant, auth.RoleReader, what)
+	if err != nil {
+		return nil, err
+	}
+	call := &grpcCall{tenant: tenant, id: id, addr: addr, method: method, start: start}
+	if err := g.r.throttle(id, addr, method); err != nil {
+		call.audit(g.r, "", 0, err)
+		return nil, err
+	}
+	return call, nil
+}
+
+//withTimeout bounds a call by the api timeout
+func (g *grpcServer) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if g.r.conf.Api.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, g.r.conf.Api.Timeout)
+}
+
+//lookupIP runs a single ip lookup, unknown ips aren't an error
+func (g *grpcServer) lookupIP(ctx context.Context, in *revdnspb.IPRequest) (*revdnspb.IPReply, error) {
+	call, err := g.authorize(ctx, in.Tenant, "LookupIP")
+	if err != nil {
+		return nil, err
+	}
+	reply, err := g.lookup(ctx, call.tenant, in)
+	results := 0
+	if reply != nil {
+		results = len(reply.Domains)
+	}
+	call.audit(g.r, in.Ip, results, err)
+	return reply, err
+}
+
+func (g *grpcServer) lookup(ctx context.Context, tenant string, in *revdnspb.IPRequest) (*revdnspb.IPReply, error) {
+	addr := net.ParseIP(in.Ip)
+	if addr == nil {
+		return nil, newAPIError(http.StatusBadRequest, "invalid ip %q", in.Ip)
+	}
+	filter := lkupFilter{source: in.Source, sensor: in.Sensor, top: int(in.Top)}
+	switch filter.source {
+	case "", revdb.SourceDNS, revdb.SourceSSL, revdb.SourceHTTP:
+	default:
+		return nil, newAPIError(http.StatusBadRequest, "unknown source %q", in.Source)
+	}
+
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+	rsp := submit(g.r.httpReq, lkupReq{
+		ctx:    ctx,
+		tenant: tenant,
+		ip:     addr.String(),
+		filter: filter,
+	})
+	reply := &revdnspb.IPReply{Ip: addr.String()}
+	if e, ok := rsp.err.(*apiError); ok && e.Code == http.StatusNotFound {
+		return reply, nil
+	}
+	if rsp.err != nil {
+		return nil, rsp.err
+	}
+	reply.Found = true
+	reply.Black = rsp.val.Black
+	reply.Attacker = rsp.val.Attacker
+	reply.Shared = rsp.val.Shared
+	for _, name := range rsp.val.Ranked() {
+		reply.Domains = append(reply.Domains, pbDomain(name, rsp.val.Domains[name]))
+	}
+	return reply, nil
+}
+
+func pbDomain(name string, info revdb.DnsInfo) *revdnspb.DomainInfo {
+	return &revdnspb.DomainInfo{
+		Name:      name,
+		Wlid:      int32(info.WlId),
+		Sources:   info.Sources,
+		Sensors:   info.Sensors,
+		Count:     int64(info.Count),
+		FirstSeen: info.FirstSeen,
+		LastSeen:  info.LastSeen,
+	}
+}
+
+//LookupIP returns the domains seen for an ip
+func (g *grpcServer) LookupIP(ctx context.Context, in *revdnspb.IPRequest) (*revdnspb.IPReply, error) {
+	reply, err := g.lookupIP(ctx, in)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return reply, nil
+}
+
+//LookupDomain returns the ips a domain was seen on
+func (g *grpcServer) LookupDomain(ctx context.Context, in *revdnspb.DomainRequest) (*revdnspb.DomainReply, error) {
+	call, err := g.authorize(ctx, in.Tenant, "LookupDomain")
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	domain := normalizeDomain(in.Domain)
+	if domain == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid domain")
+	}
+	ips, err := g.r.lookupDomain(call.tenant, domain)
+	call.audit(g.r, domain, len(ips), err)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	reply := &revdnspb.DomainReply{Domain: domain}
+	for ip, info := range ips {
+		reply.Ips = append(reply.Ips, &revdnspb.DomainIP{
+			Ip:   ip,
+			Info: pbDomain(domain, info),
+		})
+	}
+	sort.Slice(reply.Ips, func(i, j int) bool {
+		return reply.Ips[i].Ip < reply.Ips[j].Ip
+	})
+	return reply, nil
+}
+
+//StreamLookup answers a stream of ip lookups in request order. Up to
+//streamWindow lookups run concurrently, failed lookups are reported in
+//the reply's error.
+func (g *grpcServer) StreamLookup(stream revdnspb.RevDNS_StreamLookupServer) error {
+	ctx := stream.Context()
+	pending := make(chan chan *revdnspb.IPReply, streamWindow)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(pending)
+		for {
+			in, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			c := make(chan *revdnspb.IPReply, 1)
+			select {
+			case pending <- c:
+			case <-ctx.Done():
+				return
+			}
+			go func(in *revdnspb.IPRequest) {
+				reply, err := g.lookupIP(ctx, in)
+				if err != nil {
+					reply = &revdnspb.IPReply{Ip: in.Ip, Error: err.Error()}
+				}
+				c <- reply
+			}(in)
+		}
+	}()
+
+	for c := range pending {
+		if err := stream.Send(<-c); err != nil {
+			return err
+		}
+	}
+	select {
+	case err := <-recvErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+//Subscribe streams the tenant's new ip/domain pairs until the client
+//goes away or falls behind
+func (g *grpcServer) Subscribe(in *revdnspb.SubscribeRequest, stream revdnspb.RevDNS_SubscribeServer) error {
+	call, err := g.authorize(stream.Context(), in.Tenant, "Subscribe")
+	if err != nil {
+		return grpcError(err)
+	}
+	sub := g.r.feed.subscribe(call.tenant, nil)
+	defer g.r.feed.unsubscribe(sub)
+	sent := 0
+	defer func() {
+		call.audit(g.r, "", sent, nil)
+	}()
+	for {
+		select {
+		case m := <-sub.c:
+			err := stream.Send(&revdnspb.Mapping{
+				Ip:     m.IP,
+				Domain: m.Domain,
+				NewIp:  m.NewIP,
+				Source: m.Source,
+				Sensor: m.Sensor,
+				Wlid:   int32(m.WlId),
+				Time:   m.Time,
+			})
+			if err != nil {
+				return err
+			}
+			sent++
+		case <-sub.dropped:
+			return status.Error(codes.ResourceExhausted, "subscriber fell behind")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+//Component states reported by /readyz
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+//inputStatus a consumer of the input stream
+type inputStatus interface {
+	status() (connected bool, lastErr error, lag int64)
+}
+
+//componentStatus state of one component, Message explains why it isn't ok
+type componentStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Lag     *int64 `json:"lag,omitempty"`
+}
+
+func okStatus() componentStatus {
+	return componentStatus{Status: statusOK}
+}
+
+func unavailableStatus(format string, args ...interface{}) componentStatus {
+	return componentStatus{Status: statusUnavailable, Message: fmt.Sprintf(format, args...)}
+}
+
+//readiness reports the db, whitelist and input status
+func (r *revDns) readiness() map[string]componentStatus {
+	comps := make(map[string]componentStatus)
+
+	r.dbLock.Lock()
+	db, open := r.dbs[defaultTenant]
+	r.dbLock.Unlock()
+	if open {
+		comps["db"] = okStatus()
+		comps["whitelist"] = okStatus()
+		if w, isBolt := backend(db).(interface{ WhitelistSize() int }); isBolt && w.WhitelistSize() == 0 {
+			comps["whitelist"] = unavailableStatus("whitelist is empty")
+		}
+	} else {
+		comps["db"] = unavailableStatus("database not open")
+		comps["whitelist"] = unavailableStatus("database not open")
+	}
+
+	if r.input == nil {
+		comps["input"] = unavailableStatus("input not started")
+		return comps
+	}
+	connected, err, lag := r.input.status()
+	switch {
+	case !connected && err != nil:
+		comps["input"] = unavailableStatus("not connected: %s", err)
+	case !connected:
+		comps["input"] = unavailableStatus("not connected")
+	case r.conf.Kafka.MaxLag > 0 && lag > r.conf.Kafka.MaxLag:
+		comps["input"] = unavailableStatus("lagging %d messages behind", lag)
+	default:
+		comps["input"] = okStatus()
+		if err != nil {
+			//connected again, report the last error
+			comps["input"] = componentStatus{Status: statusOK, Message: err.Error()}
+		}
+	}
+	if connected {
+		in := comps["input"]
+		in.Lag = &lag
+		comps["input"] = in
+	}
+	return comps
+}
+
+//healthzHandler answers as long as the process serves requests
+func (r *revDns) healthzHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": statusOK})
+}
+
+//readyzHandler answers 200 when every component is ok, 503 otherwise
+func (r *revDns) readyzHandler(w http.ResponseWriter, req *http.Request) {
+	comps := r.readiness()
+	status, code := statusOK, http.StatusOK
+	for _, c := range comps {
+		if c.Status != statusOK {
+			status, code = statusUnavailable, http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     status,
+		"components": comps,
+	})
+}
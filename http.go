@@ -1,10 +1,19 @@
 package main
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gviz/revDNS/internal/revdb"
 )
 
 type lkup struct {
@@ -12,27 +21,264 @@ type lkup struct {
 }
 
 type lkupReq struct {
-	ip string
-	w  http.ResponseWriter
-	c  chan struct{}
+	ctx    context.Context
+	tenant string
+	ip     string
+	filter lkupFilter
+	rsp    chan lkupRsp
+}
+
+type lkupRsp struct {
+	val revdb.DnsVal
+	err error
+}
+
+//Lookup filters taken from query parameters
+type lkupFilter struct {
+	source string
+	sensor string
+	top    int
+	//as-of window in unix seconds, from == to for ?at=
+	timed    bool
+	from, to int64
+	rank     rankFilter
+}
+
+func newLkupFilter(q url.Values) (lkupFilter, error) {
+	f := lkupFilter{
+		source: q.Get("source"),
+		sensor: q.Get("sensor"),
+	}
+	switch f.source {
+	case "", revdb.SourceDNS, revdb.SourceSSL, revdb.SourceHTTP:
+	default:
+		return f, fmt.Errorf("unknown source %q", f.source)
+	}
+	if top := q.Get("top"); top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil || n <= 0 {
+			return f, fmt.Errorf("invalid top %q", top)
+		}
+		f.top = n
+	}
+	if err := f.parseWindow(q); err != nil {
+		return f, err
+	}
+	var err error
+	f.rank, err = newRankFilter(q)
+	return f, err
+}
+
+//parseWindow reads ?at= or ?from=&to=
+func (f *lkupFilter) parseWindow(q url.Values) error {
+	at, from, to := q.Get("at"), q.Get("from"), q.Get("to")
+	if at == "" && from == "" && to == "" {
+		return nil
+	}
+	if at != "" && (from != "" || to != "") {
+		return fmt.Errorf("at can't be combined with from/to")
+	}
+	f.timed = true
+	f.from, f.to = 0, math.MaxInt64
+	if at != "" {
+		from, to = at, at
+	}
+	if from != "" {
+		t, err := revdb.ParseTime(from)
+		if err != nil {
+			return fmt.Errorf("invalid time %q", from)
+		}
+		f.from = t.Unix()
+	}
+	if to != "" {
+		t, err := revdb.ParseTime(to)
+		if err != nil {
+			return fmt.Errorf("invalid time %q", to)
+		}
+		f.to = t.Unix()
+	}
+	if f.from > f.to {
+		return fmt.Errorf("from is after to")
+	}
+	return nil
+}
+
+func (f lkupFilter) match(name string, info revdb.DnsInfo) bool {
+	if f.source != "" && !info.HasSource(f.source) {
+		return false
+	}
+	if f.sensor != "" && !info.HasSensor(f.sensor) {
+		return false
+	}
+	if f.timed && !info.SeenBetween(f.from, f.to) {
+		return false
+	}
+	return f.rank.match(info.WlId)
+}
+
+func (f lkupFilter) apply(val revdb.DnsVal) revdb.DnsVal {
+	if f.source != "" || f.sensor != "" || f.timed || f.rank != (rankFilter{}) {
+		val = val.Filter(f.match)
+	}
+	return val.Top(f.top)
+}
+
+//rankFilter selects domains by whitelist rank, rank 0 is unranked
+type rankFilter struct {
+	//only domains ranked 1 to maxRank
+	maxRank int
+	//only domains without a rank
+	unranked bool
+}
+
+func newRankFilter(q url.Values) (rankFilter, error) {
+	var f rankFilter
+	if max := q.Get("max_rank"); max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil || n <= 0 {
+			return f, fmt.Errorf("invalid max_rank %q", max)
+		}
+		f.maxRank = n
+	}
+	switch q.Get("unranked_only") {
+	case "", "false":
+	case "true":
+		f.unranked = true
+	default:
+		return f, fmt.Errorf("invalid unranked_only %q", q.Get("unranked_only"))
+	}
+	if f.unranked && f.maxRank > 0 {
+		return f, fmt.Errorf("max_rank can't be combined with unranked_only")
+	}
+	return f, nil
+}
+
+func (f rankFilter) match(rank int) bool {
+	if f.unranked {
+		return rank == 0
+	}
+	if f.maxRank > 0 {
+		return rank > 0 && rank <= f.maxRank
+	}
+	return true
 }
 
 func (l *lkup) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	ret := make(chan struct{})
-	if vars == nil {
+	addr := net.ParseIP(vars["ip"])
+	if addr == nil {
+		badRequest(w, "invalid ip %q", vars["ip"])
+		return
+	}
+	filter, err := newLkupFilter(r.URL.Query())
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	format, err := negotiate(r.Header.Get("Accept"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	rsp := submit(l.c, lkupReq{
+		ctx:    r.Context(),
+		tenant: tenantOf(r),
+		ip:     addr.String(),
+		filter: filter,
+	})
+	if rsp.err != nil {
+		writeError(w, rsp.err)
+		return
+	}
+	auditResults(r, len(rsp.val.Domains))
+	if format != mimeJSON {
+		enc := newRecordEncoder(format, w, "revDNS lookup of "+addr.String())
+		enc.write(revdb.Record{IP: addr.String(), DnsVal: rsp.val})
+		enc.close()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rsp.val)
+}
+
+//submit queues a lookup for dbHandler and waits for the answer
+func submit(c chan lkupReq, req lkupReq) lkupRsp {
+	req.rsp = make(chan lkupRsp, 1)
+	select {
+	case c <- req:
+	case <-req.ctx.Done():
+		return lkupRsp{err: errTimeout}
+	}
+	select {
+	case rsp := <-req.rsp:
+		return rsp
+	case <-req.ctx.Done():
+		return lkupRsp{err: errTimeout}
+	}
+}
+
+//domainHandler serves the ips a domain was seen on
+func (r *revDns) domainHandler(w http.ResponseWriter, req *http.Request) {
+	domain := normalizeDomain(mux.Vars(req)["domain"])
+	if domain == "" {
+		badRequest(w, "invalid domain")
+		return
+	}
+	filter, err := newLkupFilter(req.URL.Query())
+	if err != nil {
+		badRequest(w, "%s", err)
 		return
 	}
-	log.Println(r)
-	ip, ok := vars["ip"]
-	if ok == false {
+	ips, err := r.lookupDomain(tenantOf(req), domain)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+	for ip, info := range ips {
+		if !filter.match(domain, info) {
+			delete(ips, ip)
+		}
+	}
+	auditResults(req, len(ips))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"domain": domain,
+		"ips":    ips,
+	})
+}
+
+//normalizeDomain lower cases a domain and drops the root dot
+func normalizeDomain(domain string) string {
+	return revdb.NormalizeName(domain)
+}
+
+//withTimeout bounds requests to h by the api timeout
+func withTimeout(h http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		h.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
 
-	l.c <- lkupReq{
-		ip: ip,
-		w:  w,
-		c:  ret,
+//tenantOf returns the tenant a request is scoped to
+func tenantOf(req *http.Request) string {
+	tenant, ok := mux.Vars(req)["tenant"]
+	if !ok {
+		return defaultTenant
 	}
-	<-ret
+	return strings.ToLower(tenant)
+}
+
+//statsHandler reports cache hit/miss counters and auth denials
+func (r *revDns) statsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cache":        r.cacheStats(),
+		"auth_denials": r.denialCounts(),
+	})
 }
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+)
+
+//RotatingFile appends to a file and renames it to file.1, shifting
+//older backups up, when a write would grow it past maxSize bytes. At
+//most maxBackups old files are kept.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	lock sync.Mutex
+	f    *os.File
+	size int64
+}
+
+//OpenFile opens path for appending, a maxSize of 0 never rotates
+func OpenFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+//rotate shifts the backups and starts a new file. path is reopened even
+//if the shift fails, appending to the old file, so a failed rotation
+//doesn't fail every later write. r.f is nil only if the reopen failed.
+func (r *RotatingFile) rotate() error {
+	r.f.Close()
+	err := r.shift()
+	if oerr := r.open(); oerr != nil {
+		r.f = nil
+		return oerr
+	}
+	return err
+}
+
+func (r *RotatingFile) shift() error {
+	if r.maxBackups <= 0 {
+		return os.Remove(r.path)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	return os.Rename(r.path, r.path+".1")
+}
+
+//Write appends p, rotating first when p doesn't fit. When rotation fails
+//p is still appended to the current file and rotation is retried on the
+//next write.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil && r.f == nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+//Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+//Logger writes audit entries as one JSON object per line
+type Logger struct {
+	lock sync.Mutex
+	w    io.WriteCloser
+}
+
+//New logs to w
+func New(w io.WriteCloser) *Logger {
+	return &Logger{w: w}
+}
+
+//NewSyslog logs to syslog, the local daemon when addr is empty
+func NewSyslog(network string, addr string, tag string) (*Logger, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return New(w), nil
+}
+
+//Log writes entry
+func (l *Logger) Log(entry interface{}) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, err = l.w.Write(append(data, '\n'))
+	return err
+}
+
+//Close closes the output
+func (l *Logger) Close() error {
+	return l.w.Close()
+}
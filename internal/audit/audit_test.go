@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	f, err := OpenFile(path, 64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(f)
+	for i := 0; i < 20; i++ {
+		if err := l.Log(map[string]int{"entry": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Too many backups kept")
+	}
+	last := -1
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		fh, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc := bufio.NewScanner(fh)
+		for sc.Scan() {
+			var e map[string]int
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				t.Errorf("Invalid entry %q: %s", sc.Text(), err)
+			}
+			if e["entry"] <= last {
+				t.Errorf("Entries out of order: %d after %d", e["entry"], last)
+			}
+			last = e["entry"]
+		}
+		fh.Close()
+		if fi, _ := os.Stat(name); fi.Size() > 64 {
+			t.Errorf("%s not rotated: %d bytes", name, fi.Size())
+		}
+	}
+	if last != 19 {
+		t.Errorf("Last entry %d", last)
+	}
+}
+
+func TestRotateFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	//a non empty directory in the way of the backup fails the rename
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenFile(path, 16, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write([]byte("0123456789\n")); err != nil {
+			t.Fatalf("Write %d after failed rotation: %s", i, err)
+		}
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 55 {
+		t.Errorf("Writes lost: %v %s", fi, err)
+	}
+
+	//rotation resumes once the backup can be written
+	os.RemoveAll(path + ".1")
+	f.Write([]byte("0123456789\n"))
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 11 {
+		t.Errorf("Not rotated: %v %s", fi, err)
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+//Roles, admin includes reader
+const (
+	RoleReader = "reader"
+	RoleAdmin  = "admin"
+)
+
+//Authentication methods
+const (
+	MethodNone = "none"
+	MethodKey  = "key"
+	MethodCert = "cert"
+	MethodJWT  = "jwt"
+)
+
+//AllTenants in Identity.Tenants grants access to every tenant
+const AllTenants = "*"
+
+//ErrNoCredentials the caller presented no credentials
+var ErrNoCredentials = errors.New("no credentials")
+
+//Identity an authenticated caller
+type Identity struct {
+	Name   string
+	Method string
+	Role   string
+	//Tenants the caller may access
+	Tenants []string
+}
+
+//Allowed reports whether the identity holds role
+func (id *Identity) Allowed(role string) bool {
+	switch id.Role {
+	case RoleAdmin:
+		return role == RoleAdmin || role == RoleReader
+	case RoleReader:
+		return role == RoleReader
+	}
+	return false
+}
+
+//InTenant reports whether the identity may access tenant
+func (id *Identity) InTenant(tenant string) bool {
+	for _, t := range id.Tenants {
+		if t == tenant || t == AllTenants {
+			return true
+		}
+	}
+	return false
+}
+
+//ValidRole reports whether role is known
+func ValidRole(role string) bool {
+	return role == RoleReader || role == RoleAdmin
+}
+
+//Credentials presented by a caller
+type Credentials struct {
+	APIKey string
+	//Bearer token of the Authorization header
+	Bearer string
+	//Verified client certificate chain, leaf first
+	Certs []*x509.Certificate
+}
+
+//Error a failed authentication, Who names the rejected credential
+//without revealing it
+type Error struct {
+	Who string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Who + ": " + e.Err.Error()
+}
+
+//Authenticator maps credentials to identities. Api keys are checked
+//first, then bearer tokens, then client certificates.
+type Authenticator struct {
+	lock      sync.RWMutex
+	keys      map[string]Identity
+	certs     map[string]Identity
+	jwt       *JWTVerifier
+	anonymous *Identity
+}
+
+//New returns an authenticator without any credentials
+func New() *Authenticator {
+	return &Authenticator{
+		keys:  make(map[string]Identity),
+		certs: make(map[string]Identity),
+	}
+}
+
+//KeyID fingerprint of an api key, safe to log
+func KeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:4])
+}
+
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return string(sum[:])
+}
+
+//AddKey admits callers presenting key as id
+func (a *Authenticator) AddKey(key string, id Identity) error {
+	if key == "" {
+		return fmt.Errorf("empty api key for %s", id.Name)
+	}
+	if !ValidRole(id.Role) {
+		return fmt.Errorf("unknown role %q for %s", id.Role, id.Name)
+	}
+	id.Method = MethodKey
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.keys[keyHash(key)]; ok {
+		return fmt.Errorf("duplicate api key for %s", id.Name)
+	}
+	a.keys[keyHash(key)] = id
+	return nil
+}
+
+//AddCert admits callers with a verified client certificate for
+//common name cn as id
+func (a *Authenticator) AddCert(cn string, id Identity) error {
+	if !ValidRole(id.Role) {
+		return fmt.Errorf("unknown role %q for %s", id.Role, cn)
+	}
+	id.Method = MethodCert
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.certs[cn] = id
+	return nil
+}
+
+//SetJWT admits callers with bearer tokens accepted by v
+func (a *Authenticator) SetJWT(v *JWTVerifier) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.jwt = v
+}
+
+//SetAnonymous sets the identity of callers without credentials,
+//nil rejects them
+func (a *Authenticator) SetAnonymous(id *Identity) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.anonymous = id
+}
+
+//Authenticate returns the identity of c. Presented credentials that
+//don't check out are rejected even if anonymous access is allowed.
+func (a *Authenticator) Authenticate(c Credentials) (*Identity, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	if c.APIKey != "" {
+		id, ok := a.keys[keyHash(c.APIKey)]
+		if !ok {
+			return nil, &Error{Who: KeyID(c.APIKey), Err: errors.New("unknown api key")}
+		}
+		return &id, nil
+	}
+	if c.Bearer != "" {
+		if a.jwt == nil {
+			return nil, &Error{Who: "jwt", Err: errors.New("bearer tokens not accepted")}
+		}
+		id, err := a.jwt.Verify(c.Bearer)
+		if err != nil {
+			return nil, &Error{Who: "jwt", Err: err}
+		}
+		return id, nil
+	}
+	if len(c.Certs) > 0 {
+		cn := c.Certs[0].Subject.CommonName
+		id, ok := a.certs[cn]
+		if !ok {
+			return nil, &Error{Who: "cert:" + cn, Err: errors.New("unknown client certificate")}
+		}
+		return &id, nil
+	}
+	if a.anonymous != nil {
+		id := *a.anonymous
+		return &id, nil
+	}
+	return nil, &Error{Who: "anonymous", Err: ErrNoCredentials}
+}
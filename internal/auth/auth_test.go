@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func enc(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func sign(t *testing.T, alg string, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	signed := enc(map[string]string{"alg": alg, "kid": kid}) + "." + enc(claims)
+	sum := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWT(t *testing.T) {
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b := base64.RawURLEncoding.EncodeToString
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"r1","n":%q,"e":%q},
+		{"kty":"EC","kid":"e1","crv":"P-256","x":%q,"y":%q}]}`,
+		b(rk.N.Bytes()), b(big.NewInt(int64(rk.E)).Bytes()),
+		b(ek.X.Bytes()), b(ek.Y.Bytes()))
+	keys, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &JWTVerifier{
+		conf: JWTConfig{Issuer: "idp", RoleClaim: "role", TenantsClaim: "tenants", DefaultTenant: "default"},
+		keys: keys,
+		now:  time.Now,
+	}
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	claims := map[string]interface{}{"sub": "alice", "role": RoleAdmin, "iss": "idp", "exp": exp}
+
+	for _, c := range []struct {
+		alg string
+		kid string
+		key crypto.Signer
+	}{{"RS256", "r1", rk}, {"ES256", "e1", ek}} {
+		id, err := v.Verify(sign(t, c.alg, c.kid, c.key, claims))
+		if err != nil || id.Name != "alice" || !id.Allowed(RoleReader) || !id.InTenant("default") {
+			t.Errorf("%s: invalid identity %v %v", c.alg, id, err)
+		}
+	}
+
+	if _, err := v.Verify(sign(t, "RS256", "e1", rk, claims)); err == nil {
+		t.Errorf("Accepted token for wrong key")
+	}
+	claims["exp"] = float64(time.Now().Add(-time.Minute).Unix())
+	if _, err := v.Verify(sign(t, "RS256", "r1", rk, claims)); err == nil {
+		t.Errorf("Accepted expired token")
+	}
+	claims["exp"], claims["iss"] = exp, "other"
+	if _, err := v.Verify(sign(t, "RS256", "r1", rk, claims)); err == nil {
+		t.Errorf("Accepted token of other issuer")
+	}
+	claims["iss"], claims["role"] = "idp", "root"
+	if _, err := v.Verify(sign(t, "RS256", "r1", rk, claims)); err == nil {
+		t.Errorf("Accepted unknown role")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := New()
+	if err := a.AddKey("k1", Identity{Name: "soc", Role: RoleReader, Tenants: []string{"default"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddKey("k2", Identity{Name: "x", Role: "root"}); err == nil {
+		t.Errorf("Accepted unknown role")
+	}
+	a.AddCert("enricher", Identity{Name: "enricher", Role: RoleAdmin, Tenants: []string{AllTenants}})
+
+	id, err := a.Authenticate(Credentials{APIKey: "k1"})
+	if err != nil || id.Name != "soc" || id.Method != MethodKey || id.Allowed(RoleAdmin) {
+		t.Errorf("Invalid key identity: %v %v", id, err)
+	}
+	if _, err := a.Authenticate(Credentials{APIKey: "nope"}); err == nil {
+		t.Errorf("Accepted unknown key")
+	} else if e := err.(*Error); e.Who != KeyID("nope") {
+		t.Errorf("Invalid denial: %v", e)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "enricher"}}
+	id, err = a.Authenticate(Credentials{Certs: []*x509.Certificate{cert}})
+	if err != nil || !id.Allowed(RoleAdmin) || !id.InTenant("acme") {
+		t.Errorf("Invalid cert identity: %v %v", id, err)
+	}
+
+	if _, err := a.Authenticate(Credentials{}); err == nil {
+		t.Errorf("Accepted missing credentials")
+	}
+	a.SetAnonymous(&Identity{Name: "anonymous", Method: MethodNone, Role: RoleReader})
+	if id, err := a.Authenticate(Credentials{}); err != nil || id.Name != "anonymous" {
+		t.Errorf("Invalid anonymous identity: %v %v", id, err)
+	}
+	if _, err := a.Authenticate(Credentials{APIKey: "nope"}); err == nil {
+		t.Errorf("Bad key fell back to anonymous")
+	}
+}
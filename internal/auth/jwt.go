@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+//JWTConfig claims checked on bearer tokens. Issuer and Audience are
+//only checked when set.
+type JWTConfig struct {
+	Issuer   string
+	Audience string
+	//Claim holding the role, a string
+	RoleClaim string
+	//Claim holding the tenants, a list of strings. Tokens without it
+	//may only access the default tenant.
+	TenantsClaim  string
+	DefaultTenant string
+}
+
+//JWTVerifier checks RS256 and ES256 signed tokens against a JWKS
+type JWTVerifier struct {
+	conf JWTConfig
+	keys map[string]crypto.PublicKey
+	now  func() time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	//RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	//EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func b64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("ec point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+//ParseJWKS reads the signing keys of a JWKS document
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %s", i, err)
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+//NewJWTVerifier loads the JWKS file at path
+func NewJWTVerifier(path string, conf JWTConfig) (*JWTVerifier, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if conf.RoleClaim == "" {
+		conf.RoleClaim = "role"
+	}
+	if conf.TenantsClaim == "" {
+		conf.TenantsClaim = "tenants"
+	}
+	return &JWTVerifier{conf: conf, keys: keys, now: time.Now}, nil
+}
+
+//key picks the verification key of a token, by kid or the only key
+func (v *JWTVerifier) key(kid string) (crypto.PublicKey, error) {
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func verifySignature(alg string, pub crypto.PublicKey, signed []byte, sig []byte) error {
+	sum := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		k, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 token for non rsa key")
+		}
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig)
+	case "ES256":
+		k, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("ES256 token for non ec key")
+		}
+		if len(sig) != 64 {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, sum[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported alg %q", alg)
+}
+
+//audience matches the aud claim, a string or a list
+func audience(aud interface{}, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Verify checks a token and returns the identity it carries
+func (v *JWTVerifier) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	data, err := b64(parts[0])
+	if err == nil {
+		err = json.Unmarshal(data, &hdr)
+	}
+	if err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	pub, err := v.key(hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := b64(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	if err := verifySignature(hdr.Alg, pub, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	data, err = b64(parts[1])
+	if err == nil {
+		err = json.Unmarshal(data, &claims)
+	}
+	if err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	now := float64(v.now().Unix())
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token without expiry")
+	}
+	if now >= exp {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, errors.New("token not yet valid")
+	}
+	if v.conf.Issuer != "" && claims["iss"] != v.conf.Issuer {
+		return nil, errors.New("invalid issuer")
+	}
+	if v.conf.Audience != "" && !audience(claims["aud"], v.conf.Audience) {
+		return nil, errors.New("invalid audience")
+	}
+
+	id := &Identity{Method: MethodJWT}
+	id.Name, _ = claims["sub"].(string)
+	if id.Name == "" {
+		return nil, errors.New("token without subject")
+	}
+	id.Role, _ = claims[v.conf.RoleClaim].(string)
+	if !ValidRole(id.Role) {
+		return nil, fmt.Errorf("invalid role %q", id.Role)
+	}
+	if tenants, ok := claims[v.conf.TenantsClaim].([]interface{}); ok {
+		for _, t := range tenants {
+			if s, ok := t.(string); ok {
+				id.Tenants = append(id.Tenants, strings.ToLower(s))
+			}
+		}
+	} else if v.conf.DefaultTenant != "" {
+		id.Tenants = []string{v.conf.DefaultTenant}
+	}
+	return id, nil
+}
@@ -1,61 +1,99 @@
 package main
 
-//Read and dump revdb/boltdb entries
+//Read, dump and restore revdb/boltdb entries
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
 
-	"github.com/boltdb/bolt"
+	"github.com/gviz/revDNS/internal/revdb"
 )
 
-type dnsVal struct {
-	Domains map[string]struct{}
+func list(db *revdb.BoltDB) (int, error) {
+	count := 0
+	err := db.ScanDB(func(ip string, val revdb.DnsVal) error {
+		count++
+		names := make([]string, 0, len(val.Domains))
+		for name := range val.Domains {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println(ip, strings.Join(names, ","))
+		return nil
+	})
+	return count, err
 }
 
-func NewDnsVal() *dnsVal {
-	return &dnsVal{
-		Domains: make(map[string]struct{}),
+func parseFilter(cidrs, suffix, from, to string) (revdb.ExportFilter, error) {
+	f := revdb.ExportFilter{Suffix: suffix}
+	for _, cidr := range strings.Split(cidrs, ",") {
+		if cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return f, err
+		}
+		f.Nets = append(f.Nets, n)
 	}
+	if from != "" {
+		t, err := revdb.ParseTime(from)
+		if err != nil {
+			return f, err
+		}
+		f.From = t.Unix()
+	}
+	if to != "" {
+		t, err := revdb.ParseTime(to)
+		if err != nil {
+			return f, err
+		}
+		f.To = t.Unix()
+	}
+	return f, nil
 }
 
-func bulkRead() int {
-	dbPath := "./revdb.db"
-	log.Printf("Opening %s\n", dbPath)
+func main() {
+	var (
+		dbPath, cidrs, suffix, from, to string
+		export, load                    bool
+	)
+	flag.StringVar(&dbPath, "db", "./revdb.db", "revdb file")
+	flag.BoolVar(&export, "export", false, "write an NDJSON dump to stdout")
+	flag.BoolVar(&load, "import", false, "merge an NDJSON dump from stdin into the db")
+	flag.StringVar(&cidrs, "cidr", "", "export ips in these comma separated CIDRs")
+	flag.StringVar(&suffix, "suffix", "", "export domains ending in suffix")
+	flag.StringVar(&from, "from", "", "export pairs seen after (unix or RFC3339)")
+	flag.StringVar(&to, "to", "", "export pairs seen before (unix or RFC3339)")
+	flag.Parse()
 
-	db, err := bolt.Open(dbPath, 0600, nil)
+	db, err := revdb.OpenBoltDB(dbPath, !load)
 	if err != nil {
 		log.Fatal(err)
 	}
-	count := 0
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("dnsBucket"))
-
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			count++
-			fmt.Print(string(k), " ")
-			val := NewDnsVal()
-			if v != nil {
-				err := json.Unmarshal(v, &val)
-				if err != nil {
-					log.Println("Error decoding json val:", err)
-					return err
-				}
-				for key := range val.Domains {
-					fmt.Print(key)
-				}
-				fmt.Println("")
-			}
-		}
-		return nil
-	})
-	return count
-}
+	defer db.Close()
 
-func main() {
-	c := bulkRead()
-	log.Printf("Num Entries : %d\n", c)
+	var count int
+	switch {
+	case load:
+		db.SetEncoding(revdb.Encoding{Binary: true, CompressAbove: 1024})
+		count, err = revdb.Import(os.Stdin, db)
+	case export:
+		var f revdb.ExportFilter
+		if f, err = parseFilter(cidrs, suffix, from, to); err != nil {
+			log.Fatal(err)
+		}
+		count, err = revdb.Export(db, os.Stdout, f)
+	default:
+		count, err = list(db)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Num Entries : %d\n", count)
 }
@@ -0,0 +1,119 @@
+package main
+
+//Merge revdb/boltdb files of several sensors into one db
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+const batchSize = 1000
+
+//input db file and the sensor name recorded for its pairs
+type input struct {
+	path   string
+	sensor string
+}
+
+//parseInput reads path[=sensor], the sensor defaults to the file name
+func parseInput(arg string) input {
+	in := input{path: arg}
+	if i := strings.LastIndex(arg, "="); i > 0 {
+		in.path, in.sensor = arg[:i], arg[i+1:]
+	}
+	if in.sensor == "" {
+		in.sensor = strings.TrimSuffix(filepath.Base(in.path), ".db")
+	}
+	return in
+}
+
+func merge(in input, out revdb.DBLoader) (int, error) {
+	db, err := revdb.OpenBoltDB(in.path, true)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	count := 0
+	batch := make([]revdb.Record, 0, batchSize)
+	err = db.ScanDB(func(ip string, val revdb.DnsVal) error {
+		for name, info := range val.Domains {
+			info.AddSensor(in.sensor)
+			val.Domains[name] = info
+		}
+		batch = append(batch, revdb.Record{IP: ip, DnsVal: val})
+		count++
+		if len(batch) < batchSize {
+			return nil
+		}
+		err := out.LoadDB(batch)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil && len(batch) > 0 {
+		err = out.LoadDB(batch)
+	}
+	return count, err
+}
+
+func main() {
+	var (
+		outPath      string
+		gap          time.Duration
+		maxIntervals int
+	)
+	flag.StringVar(&outPath, "out", "./revdb-merged.db", "merged revdb file")
+	flag.DurationVar(&gap, "interval-gap", 24*time.Hour, "join validity intervals closer than gap")
+	flag.IntVar(&maxIntervals, "max-intervals", 64, "validity intervals kept per pair")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] db[=sensor] ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	inputs := make([]input, 0, flag.NArg())
+	for _, arg := range flag.Args() {
+		in := parseInput(arg)
+		if abs(in.path) == abs(outPath) {
+			log.Fatalf("%s is both input and output", in.path)
+		}
+		inputs = append(inputs, in)
+	}
+
+	out, err := revdb.OpenBoltDB(outPath, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	out.SetEncoding(revdb.Encoding{Binary: true, CompressAbove: 1024})
+	out.SetLimits(revdb.Limits{IntervalGap: gap, MaxIntervals: maxIntervals})
+
+	total := 0
+	for _, in := range inputs {
+		count, err := merge(in, out)
+		if err != nil {
+			log.Fatalf("%s: %s", in.path, err)
+		}
+		log.Printf("%s (%s): %d entries\n", in.path, in.sensor, count)
+		total += count
+	}
+	log.Printf("Merged %d entries into %s\n", total, outPath)
+}
+
+func abs(path string) string {
+	if p, err := filepath.Abs(path); err == nil {
+		return p
+	}
+	return path
+}
@@ -0,0 +1,73 @@
+package main
+
+//Add the names revdb knows for conn.log endpoints to Zeek logs
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/gviz/revDNS/internal/revdb"
+	"github.com/gviz/revDNS/internal/zeek"
+)
+
+func lookup(db *revdb.BoltDB) zeek.Lookup {
+	return func(ips []string) (map[string]revdb.DnsVal, error) {
+		vals := make(map[string]revdb.DnsVal)
+		err := db.ReadDBMulti(ips, func(ip string, val revdb.DnsVal) error {
+			if len(val.Domains) > 0 {
+				vals[ip] = val
+			}
+			return nil
+		})
+		return vals, err
+	}
+}
+
+func main() {
+	var (
+		dbPath string
+		opts   zeek.Options
+	)
+	flag.StringVar(&dbPath, "db", "./revdb.db", "revdb file")
+	flag.BoolVar(&opts.Orig, "orig", false, "also add orig_domains and orig_wl_rank")
+	flag.IntVar(&opts.Top, "top", 0, "domains added per ip, most observed first (0 = all)")
+	flag.Usage = func() {
+		log.Printf("Usage: %s [flags] [conn.log ...]\nEnriches the logs, or stdin, to stdout.\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	db, err := revdb.OpenBoltDB(dbPath, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var inputs []io.Reader
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		inputs = append(inputs, f)
+	}
+	if len(inputs) == 0 {
+		inputs = append(inputs, os.Stdin)
+	}
+	total := 0
+	for _, in := range inputs {
+		n, err := zeek.Enrich(in, out, lookup(db), opts)
+		if err != nil {
+			out.Flush()
+			log.Fatal(err)
+		}
+		total += n
+	}
+	log.Printf("Enriched %d records\n", total)
+}
@@ -0,0 +1,194 @@
+package ptrsrv
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//DNS wire constants used by the server
+const (
+	typePTR  = 12
+	typeANY  = 255
+	classIN  = 1
+	classANY = 255
+
+	rcodeSuccess  = 0
+	rcodeFormErr  = 1
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+	rcodeRefused  = 5
+
+	headerLen = 12
+	//without EDNS udp answers are limited to 512 bytes
+	maxUDPSize = 512
+	//tcp answers must fit their 2 byte length prefix
+	maxTCPSize = 65535 - 2
+)
+
+var errFormat = errors.New("ptrsrv: malformed message")
+
+type header struct {
+	id      uint16
+	flags   uint16
+	qdcount uint16
+}
+
+type question struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+	//raw wire bytes of the question, echoed in responses
+	raw []byte
+}
+
+func (h header) opcode() int {
+	return int(h.flags>>11) & 0xf
+}
+
+func (h header) isResponse() bool {
+	return h.flags&(1<<15) != 0
+}
+
+//parseQuery reads the header and the first question of msg
+func parseQuery(msg []byte) (header, question, error) {
+	var h header
+	var q question
+	if len(msg) < headerLen {
+		return h, q, errFormat
+	}
+	h.id = binary.BigEndian.Uint16(msg[0:])
+	h.flags = binary.BigEndian.Uint16(msg[2:])
+	h.qdcount = binary.BigEndian.Uint16(msg[4:])
+	if h.qdcount != 1 {
+		return h, q, errFormat
+	}
+
+	off := headerLen
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return h, q, errFormat
+		}
+		l := int(msg[off])
+		off++
+		if l == 0 {
+			break
+		}
+		//queries don't use compression
+		if l > 63 || off+l > len(msg) {
+			return h, q, errFormat
+		}
+		labels = append(labels, string(msg[off:off+l]))
+		off += l
+	}
+	if off+4 > len(msg) {
+		return h, q, errFormat
+	}
+	q.name = strings.ToLower(strings.Join(labels, "."))
+	q.qtype = binary.BigEndian.Uint16(msg[off:])
+	q.qclass = binary.BigEndian.Uint16(msg[off+2:])
+	q.raw = msg[headerLen : off+4]
+	return h, q, nil
+}
+
+//reverseIP returns the address of an in-addr.arpa or ip6.arpa name
+func reverseIP(name string) (net.IP, bool) {
+	name = strings.TrimSuffix(name, ".")
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		parts := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(parts) != 4 {
+			return nil, false
+		}
+		ip := make(net.IP, 4)
+		for i, p := range parts {
+			n, err := strconv.ParseUint(p, 10, 8)
+			if err != nil || (len(p) > 1 && p[0] == '0') {
+				return nil, false
+			}
+			ip[3-i] = byte(n)
+		}
+		return ip, true
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		parts := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(parts) != 32 {
+			return nil, false
+		}
+		ip := make(net.IP, 16)
+		for i, p := range parts {
+			n, err := strconv.ParseUint(p, 16, 4)
+			if err != nil || len(p) != 1 {
+				return nil, false
+			}
+			pos := 31 - i
+			if pos%2 == 0 {
+				ip[pos/2] |= byte(n) << 4
+			} else {
+				ip[pos/2] |= byte(n)
+			}
+		}
+		return ip, true
+	}
+	return nil, false
+}
+
+//isReverseZone reports whether name is served by the ptr server
+func isReverseZone(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	return name == "in-addr.arpa" || name == "ip6.arpa" ||
+		strings.HasSuffix(name, ".in-addr.arpa") || strings.HasSuffix(name, ".ip6.arpa")
+}
+
+//packName encodes a domain name without compression
+func packName(buf []byte, name string) ([]byte, bool) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return buf, false
+			}
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+	}
+	return append(buf, 0), true
+}
+
+//response builds an authoritative answer to q with PTR records for
+//names. Answers beyond maxSize are dropped and the message truncated.
+func response(h header, q question, rcode int, names []string, ttl uint32, maxSize int) []byte {
+	flags := uint16(1<<15) | uint16(h.opcode())<<11 | uint16(1<<10) | h.flags&(1<<8) | uint16(rcode)
+	msg := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(msg[0:], h.id)
+	if q.raw == nil {
+		binary.BigEndian.PutUint16(msg[2:], flags)
+		return msg
+	}
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg = append(msg, q.raw...)
+
+	ancount := 0
+	for _, name := range names {
+		rr := []byte{0xc0, headerLen, 0, typePTR, 0, classIN, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(rr[6:], ttl)
+		rdata, ok := packName(nil, name)
+		if !ok || len(rdata) > 255 {
+			continue
+		}
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+		if maxSize > 0 && len(msg)+len(rr)+len(rdata) > maxSize {
+			flags |= 1 << 9
+			break
+		}
+		msg = append(msg, rr...)
+		msg = append(msg, rdata...)
+		ancount++
+	}
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[6:], uint16(ancount))
+	return msg
+}
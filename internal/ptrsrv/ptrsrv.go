@@ -0,0 +1,132 @@
+package ptrsrv
+
+//Authoritative PTR server for in-addr.arpa/ip6.arpa backed by revdb
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+const tcpIdle = 10 * time.Second
+
+//Resolver returns the names of ip, found is false for unknown ips
+type Resolver func(ip string) (names []string, found bool, err error)
+
+//Server answers PTR queries over udp and tcp
+type Server struct {
+	Addr    string
+	TTL     uint32
+	Resolve Resolver
+}
+
+//ListenAndServe serves udp and tcp on Addr, returning on the first error
+func (s *Server) ListenAndServe() error {
+	pc, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.ServeUDP(pc) }()
+	go func() { errs <- s.ServeTCP(ln) }()
+	return <-errs
+}
+
+//ServeUDP answers queries read from pc
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+		go func() {
+			if rsp := s.handle(msg, maxUDPSize); rsp != nil {
+				pc.WriteTo(rsp, addr)
+			}
+		}()
+	}
+}
+
+//ServeTCP answers length prefixed queries on connections from ln
+func (s *Server) ServeTCP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	var l [2]byte
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdle))
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		rsp := s.handle(msg, maxTCPSize)
+		if rsp == nil {
+			return
+		}
+		binary.BigEndian.PutUint16(l[:], uint16(len(rsp)))
+		if _, err := conn.Write(append(l[:], rsp...)); err != nil {
+			return
+		}
+	}
+}
+
+//handle answers a single query, nil means drop it
+func (s *Server) handle(msg []byte, maxSize int) []byte {
+	h, q, err := parseQuery(msg)
+	if err != nil {
+		if len(msg) < headerLen {
+			return nil
+		}
+		return response(h, question{}, rcodeFormErr, nil, 0, maxSize)
+	}
+	if h.isResponse() {
+		return nil
+	}
+	if h.opcode() != 0 {
+		return response(h, q, rcodeNotImp, nil, 0, maxSize)
+	}
+	if !isReverseZone(q.name) || (q.qclass != classIN && q.qclass != classANY) {
+		return response(h, q, rcodeRefused, nil, 0, maxSize)
+	}
+
+	ip, ok := reverseIP(q.name)
+	if !ok {
+		return response(h, q, rcodeNXDomain, nil, 0, maxSize)
+	}
+	names, found, err := s.Resolve(ip.String())
+	if err != nil {
+		log.Println("PTR lookup failed:", err)
+		return response(h, q, rcodeServFail, nil, 0, maxSize)
+	}
+	if !found || len(names) == 0 {
+		return response(h, q, rcodeNXDomain, nil, 0, maxSize)
+	}
+	//the name exists, other types get an empty answer
+	if q.qtype != typePTR && q.qtype != typeANY {
+		names = nil
+	}
+	return response(h, q, rcodeSuccess, names, s.TTL, maxSize)
+}
@@ -0,0 +1,77 @@
+package ptrsrv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func query(name string, qtype uint16) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	msg, _ = packName(msg, name)
+	return append(msg, byte(qtype>>8), byte(qtype), 0, classIN)
+}
+
+func TestReverseIP(t *testing.T) {
+	for name, want := range map[string]string{
+		"4.3.2.1.in-addr.arpa.": "1.2.3.4",
+		"b.a.9.8.7.6.5.0.4.0.0.0.3.0.0.0.2.0.0.0.1.0.0.0.0.0.0.0.1.2.3.4.ip6.arpa": "4321:0:1:2:3:4:567:89ab",
+	} {
+		ip, ok := reverseIP(name)
+		if !ok || ip.String() != want {
+			t.Errorf("%s: got %v want %s", name, ip, want)
+		}
+	}
+	for _, name := range []string{"3.2.1.in-addr.arpa", "256.3.2.1.in-addr.arpa", "01.3.2.1.in-addr.arpa", "example.com"} {
+		if _, ok := reverseIP(name); ok {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	s := &Server{
+		TTL: 60,
+		Resolve: func(ip string) ([]string, bool, error) {
+			if ip == "1.2.3.4" {
+				return []string{"a.example.com", "b.example.com"}, true, nil
+			}
+			return nil, false, nil
+		},
+	}
+	rsp := s.handle(query("4.3.2.1.in-addr.arpa", typePTR), maxUDPSize)
+	if rsp[3]&0xf != rcodeSuccess || binary.BigEndian.Uint16(rsp[6:]) != 2 {
+		t.Errorf("Invalid answer: %x", rsp)
+	}
+	if binary.BigEndian.Uint16(rsp[0:]) != 0x1234 || rsp[2]&0x84 != 0x84 {
+		t.Errorf("Invalid header: %x", rsp[:4])
+	}
+
+	rsp = s.handle(query("5.3.2.1.in-addr.arpa", typePTR), maxUDPSize)
+	if rsp[3]&0xf != rcodeNXDomain {
+		t.Errorf("Expected NXDOMAIN: %x", rsp)
+	}
+	rsp = s.handle(query("www.example.com", 1), maxUDPSize)
+	if rsp[3]&0xf != rcodeRefused {
+		t.Errorf("Expected REFUSED: %x", rsp)
+	}
+
+	//truncation
+	rsp = s.handle(query("4.3.2.1.in-addr.arpa", typePTR), 80)
+	if rsp[2]&0x02 == 0 || binary.BigEndian.Uint16(rsp[6:]) != 1 {
+		t.Errorf("Expected truncated answer: %x", rsp)
+	}
+
+	//tcp answers stay within the length prefix
+	s.Resolve = func(ip string) ([]string, bool, error) {
+		names := make([]string, 5000)
+		for i := range names {
+			names[i] = fmt.Sprintf("host%d.cdn.example.com", i)
+		}
+		return names, true, nil
+	}
+	rsp = s.handle(query("4.3.2.1.in-addr.arpa", typePTR), maxTCPSize)
+	if len(rsp) > maxTCPSize || rsp[2]&0x02 == 0 {
+		t.Errorf("Oversized tcp answer: %d bytes", len(rsp))
+	}
+}
@@ -2,6 +2,7 @@ package revconfig
 
 import (
 	"log"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -12,15 +13,140 @@ type KafkaConfig struct {
 	SslStream  string
 	DnsStream  string
 	HttpStream string
+	//Sensor name used when records don't carry one
+	Sensor      string
+	SensorField string
+	//Tenant records are stored under unless TenantField names one
+	Tenant      string
+	TenantField string
+	//Lag in messages beyond which revDNS isn't ready
+	MaxLag int64
+}
+
+//TenantConfig per tenant settings, keyed by lower case tenant name
+type TenantConfig struct {
+	ApiKeys []string `mapstructure:"api_keys"`
+	//Role of the api keys, admin if unset
+	Role string
+}
+
+//AuthKey a caller identified by api key or, for certs, by the common
+//name of its client certificate
+type AuthKey struct {
+	Name    string
+	Key     string
+	Role    string
+	Tenants []string
+}
+
+//JWTConfig bearer token settings, tokens are checked against a local
+//JWKS file
+type JWTConfig struct {
+	JWKS         string `mapstructure:"jwks"`
+	Issuer       string
+	Audience     string
+	RoleClaim    string `mapstructure:"role_claim"`
+	TenantsClaim string `mapstructure:"tenants_claim"`
+}
+
+//AuthConfig api authentication. Unless Enabled, callers without
+//credentials have full access to the default tenant.
+type AuthConfig struct {
+	Enabled bool
+	Keys    []AuthKey
+	Certs   []AuthKey
+	JWT     JWTConfig
 }
 type RevAPI struct {
 	Port int
+	//Max ips per bulk lookup
+	BulkMax int
+	//Deadline for every api request
+	Timeout time.Duration
+	//gRPC listener port, 0 disables it
+	GrpcPort  int
+	TLS       TLSConfig
+	RateLimit RateLimit
 }
+
+//Quota token bucket of Rate requests per second holding up to Burst
+type Quota struct {
+	Rate  float64
+	Burst int
+}
+
+//RateLimit per client quotas, clients are authenticated identities or
+//the addresses of anonymous callers. Quotas overrides the default of
+//identities by name, a Rate of 0 is unlimited.
+type RateLimit struct {
+	Rate   float64
+	Burst  int
+	Quotas map[string]Quota
+}
+
+//TLSConfig api TLS settings, the api is served over TLS when Cert is set
+type TLSConfig struct {
+	Cert string
+	Key  string
+	//CA verifying client certificates, certificates are only asked for
+	//when set
+	ClientCA          string
+	RequireClientCert bool
+	//"1.0" to "1.3"
+	MinVersion string
+}
+
+//DBConfig revdb settings
+type DBConfig struct {
+	//lru of decoded values and of unknown ips, 0 disables
+	CacheSize    int
+	NegCacheSize int
+	NegCacheTTL  time.Duration
+	//Domains kept per ip and eviction policy ("lrs" or "count")
+	MaxDomains int
+	Eviction   string
+	//Observations closer than IntervalGap extend a pair's validity interval
+	IntervalGap  time.Duration
+	MaxIntervals int
+	//Value encoding ("binary" or "json"), binary values larger than
+	//CompressAbove bytes are compressed
+	Encoding      string
+	CompressAbove int
+}
+
+//DNSConfig PTR server settings
+type DNSConfig struct {
+	Enabled bool
+	Listen  string
+	TTL     time.Duration
+	//Answer with all names instead of the best ranked one
+	All bool
+}
+
+//AuditConfig query audit log, Output is "file" or "syslog"
+type AuditConfig struct {
+	Enabled bool
+	Output  string
+	//Rotated when it would grow past MaxSize MB, MaxBackups kept
+	File       string
+	MaxSize    int
+	MaxBackups int
+	//Syslog daemon, the local one when Address is empty
+	SyslogNetwork string
+	SyslogAddress string
+	SyslogTag     string
+}
+
 type RevConfig struct {
 	InputType string
 	Api       RevAPI
 	Kafka     KafkaConfig
 	Checks    ProcessingConfig
+	DB        DBConfig
+	DNS       DNSConfig
+	Tenants   map[string]TenantConfig
+	Auth      AuthConfig
+	Audit     AuditConfig
 }
 
 type ProcessingConfig struct {
@@ -36,27 +162,81 @@ func InitConfig() *RevConfig {
 	viper.SetConfigName("revdns")
 	viper.AddConfigPath(".")
 	viper.SetDefault("api.port", 9090)
+	viper.SetDefault("api.bulk_max", 10000)
+	viper.SetDefault("api.timeout", "10s")
+	viper.SetDefault("api.grpc_port", 0)
+	viper.SetDefault("api.tls.min_version", "1.2")
+	viper.SetDefault("audit.output", "file")
+	viper.SetDefault("audit.file", "./audit.log")
+	viper.SetDefault("audit.max_size", 100)
+	viper.SetDefault("audit.max_backups", 10)
+	viper.SetDefault("audit.syslog.tag", "revdns")
 	viper.SetDefault("input.type", "kafka")
 	viper.SetDefault("input.ssl.stream", "network")
 	viper.SetDefault("input.dns.stream", "network")
+	viper.SetDefault("input.sensor_field", "@sensor")
+	viper.SetDefault("input.tenant", "default")
+	viper.SetDefault("input.max_lag", 10000)
 	viper.SetDefault("Processing.Lists.Alexa", true)
+	viper.SetDefault("dns.listen", ":5353")
+	viper.SetDefault("dns.ttl", "5m")
+	viper.SetDefault("db.cache.size", 100000)
+	viper.SetDefault("db.cache.negative_size", 100000)
+	viper.SetDefault("db.cache.negative_ttl", "5m")
+	viper.SetDefault("db.max_domains", 0)
+	viper.SetDefault("db.eviction", "lrs")
+	viper.SetDefault("db.interval_gap", "24h")
+	viper.SetDefault("db.max_intervals", 64)
+	viper.SetDefault("db.encoding", "binary")
+	viper.SetDefault("db.compress_above", 1024)
 
 	err := viper.ReadInConfig()
 	if err != nil {
 		log.Println(err)
 		return nil
 	}
+	tenants := make(map[string]TenantConfig)
+	if err := viper.UnmarshalKey("tenants", &tenants); err != nil {
+		log.Println(err)
+		return nil
+	}
+	var authConf AuthConfig
+	if err := viper.UnmarshalKey("auth", &authConf); err != nil {
+		log.Println(err)
+		return nil
+	}
+	var rateLimit RateLimit
+	if err := viper.UnmarshalKey("api.rate_limit", &rateLimit); err != nil {
+		log.Println(err)
+		return nil
+	}
 	return &RevConfig{
 		InputType: viper.GetString("intput.type"),
 		Api: RevAPI{
-			Port: viper.GetInt("api.port"),
+			Port:     viper.GetInt("api.port"),
+			BulkMax:  viper.GetInt("api.bulk_max"),
+			Timeout:  viper.GetDuration("api.timeout"),
+			GrpcPort: viper.GetInt("api.grpc_port"),
+			TLS: TLSConfig{
+				Cert:              viper.GetString("api.tls.cert"),
+				Key:               viper.GetString("api.tls.key"),
+				ClientCA:          viper.GetString("api.tls.client_ca"),
+				RequireClientCert: viper.GetBool("api.tls.require_client_cert"),
+				MinVersion:        viper.GetString("api.tls.min_version"),
+			},
+			RateLimit: rateLimit,
 		},
 		Kafka: KafkaConfig{
-			Host:       viper.GetString("input.host"),
-			Topic:      viper.GetString("input.topic"),
-			SslStream:  viper.GetString("input.stream_ssl"),
-			DnsStream:  viper.GetString("input.stream_dns"),
-			HttpStream: viper.GetString("input.stream_http"),
+			Host:        viper.GetString("input.host"),
+			Topic:       viper.GetString("input.topic"),
+			SslStream:   viper.GetString("input.stream_ssl"),
+			DnsStream:   viper.GetString("input.stream_dns"),
+			HttpStream:  viper.GetString("input.stream_http"),
+			Sensor:      viper.GetString("input.sensor"),
+			SensorField: viper.GetString("input.sensor_field"),
+			Tenant:      viper.GetString("input.tenant"),
+			TenantField: viper.GetString("input.tenant_field"),
+			MaxLag:      viper.GetInt64("input.max_lag"),
 		},
 		Checks: ProcessingConfig{
 			Alexa:         viper.GetBool("Processing.Lists.Alexa.enabled"),
@@ -65,5 +245,34 @@ func InitConfig() *RevConfig {
 			BlacklistFile: viper.GetString("Processing.Lists.Blacklist.file"),
 			Suricata:      viper.GetBool("Processing.Attacks.Suricata"),
 		},
+		DB: DBConfig{
+			CacheSize:     viper.GetInt("db.cache.size"),
+			NegCacheSize:  viper.GetInt("db.cache.negative_size"),
+			NegCacheTTL:   viper.GetDuration("db.cache.negative_ttl"),
+			MaxDomains:    viper.GetInt("db.max_domains"),
+			Eviction:      viper.GetString("db.eviction"),
+			IntervalGap:   viper.GetDuration("db.interval_gap"),
+			MaxIntervals:  viper.GetInt("db.max_intervals"),
+			Encoding:      viper.GetString("db.encoding"),
+			CompressAbove: viper.GetInt("db.compress_above"),
+		},
+		DNS: DNSConfig{
+			Enabled: viper.GetBool("dns.enabled"),
+			Listen:  viper.GetString("dns.listen"),
+			TTL:     viper.GetDuration("dns.ttl"),
+			All:     viper.GetBool("dns.all"),
+		},
+		Tenants: tenants,
+		Auth:    authConf,
+		Audit: AuditConfig{
+			Enabled:       viper.GetBool("audit.enabled"),
+			Output:        viper.GetString("audit.output"),
+			File:          viper.GetString("audit.file"),
+			MaxSize:       viper.GetInt("audit.max_size"),
+			MaxBackups:    viper.GetInt("audit.max_backups"),
+			SyslogNetwork: viper.GetString("audit.syslog.network"),
+			SyslogAddress: viper.GetString("audit.syslog.address"),
+			SyslogTag:     viper.GetString("audit.syslog.tag"),
+		},
 	}
 }
@@ -0,0 +1,167 @@
+package revdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+//Annotations live in their own buckets and are never touched by writes
+//or purges of the mapping data.
+const (
+	annotationBucket = "annotations"
+	tagBucket        = "annotationTags"
+)
+
+//Annotated object kinds
+const (
+	AnnotateIP     = "ip"
+	AnnotateDomain = "domain"
+)
+
+//Annotation analyst tags and notes on an ip or domain
+type Annotation struct {
+	Tags   []string `json:"tags,omitempty"`
+	Note   string   `json:"note,omitempty"`
+	Author string   `json:"author"`
+	Time   int64    `json:"time"`
+}
+
+//AnnotationRef an annotation and the object it belongs to
+type AnnotationRef struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+	Annotation
+}
+
+//Annotator stores analyst annotations
+type Annotator interface {
+	Annotate(kind string, key string, a Annotation) error
+	//Annotations returns the annotations of keys of the same kind
+	Annotations(kind string, keys []string) (map[string][]Annotation, error)
+	SearchTag(tag string) ([]AnnotationRef, error)
+}
+
+//HasTag reports whether any of the annotations carries tag
+func HasTag(annotations []Annotation, tag string) bool {
+	for _, a := range annotations {
+		if inSet(a.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func annotationKey(kind string, key string) []byte {
+	return []byte(kind + ":" + key)
+}
+
+func tagKey(tag string, kind string, key string) []byte {
+	return []byte(tag + "\x00" + kind + ":" + key)
+}
+
+//Annotate appends an annotation to an ip or domain
+func (b *BoltDB) Annotate(kind string, key string, a Annotation) error {
+	if kind != AnnotateIP && kind != AnnotateDomain {
+		return fmt.Errorf("unknown annotation kind %q", kind)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(annotationBucket))
+		if err != nil {
+			return err
+		}
+		tags, err := tx.CreateBucketIfNotExists([]byte(tagBucket))
+		if err != nil {
+			return err
+		}
+
+		var list []Annotation
+		if v := bkt.Get(annotationKey(kind, key)); v != nil {
+			if err := json.Unmarshal(v, &list); err != nil {
+				return err
+			}
+		}
+		list = append(list, a)
+		data, err := json.Marshal(list)
+		if err != nil {
+			return err
+		}
+		if err := bkt.Put(annotationKey(kind, key), data); err != nil {
+			return err
+		}
+		for _, tag := range a.Tags {
+			if err := tags.Put(tagKey(tag, kind, key), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//Annotations returns the annotations of keys in a single transaction
+func (b *BoltDB) Annotations(kind string, keys []string) (map[string][]Annotation, error) {
+	ret := make(map[string][]Annotation)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(annotationBucket))
+		if bkt == nil {
+			return nil
+		}
+		for _, key := range keys {
+			v := bkt.Get(annotationKey(kind, key))
+			if v == nil {
+				continue
+			}
+			var list []Annotation
+			if err := json.Unmarshal(v, &list); err != nil {
+				return err
+			}
+			ret[key] = list
+		}
+		return nil
+	})
+	return ret, err
+}
+
+//SearchTag returns the annotations carrying tag
+func (b *BoltDB) SearchTag(tag string) ([]AnnotationRef, error) {
+	var refs []AnnotationRef
+	err := b.db.View(func(tx *bolt.Tx) error {
+		tags := tx.Bucket([]byte(tagBucket))
+		bkt := tx.Bucket([]byte(annotationBucket))
+		if tags == nil || bkt == nil {
+			return nil
+		}
+		prefix := []byte(tag + "\x00")
+		c := tags.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			obj := string(k[len(prefix):])
+			sep := strings.Index(obj, ":")
+			if sep < 0 {
+				continue
+			}
+			//tag entries can outlive a rewritten annotation
+			v := bkt.Get([]byte(obj))
+			if v == nil {
+				continue
+			}
+			var list []Annotation
+			if err := json.Unmarshal(v, &list); err != nil {
+				return err
+			}
+			for _, a := range list {
+				if inSet(a.Tags, tag) {
+					refs = append(refs, AnnotationRef{
+						Kind:       obj[:sep],
+						Key:        obj[sep+1:],
+						Annotation: a,
+					})
+				}
+			}
+		}
+		return nil
+	})
+	return refs, err
+}
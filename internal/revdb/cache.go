@@ -0,0 +1,330 @@
+package revdb
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+//number of invalidation stripes guarding cache fills
+const cacheStripes = 256
+
+//CacheStats counters for CachedDB
+type CacheStats struct {
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`
+	NegativeHits uint64 `json:"negative_hits"`
+	Evictions    uint64 `json:"evictions"`
+	Invalidated  uint64 `json:"invalidated"`
+	Entries      int    `json:"entries"`
+	NegEntries   int    `json:"negative_entries"`
+}
+
+type cacheEntry struct {
+	ip      string
+	val     DnsVal
+	expires time.Time
+}
+
+//lruCache size bounded lru of decoded values
+type lruCache struct {
+	size    int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+func newLruCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(ip string) (*cacheEntry, bool) {
+	e, ok := c.entries[ip]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry), true
+}
+
+//add stores an entry and returns the number of evicted entries
+func (c *lruCache) add(ent *cacheEntry) int {
+	if c.size <= 0 {
+		return 0
+	}
+	if e, ok := c.entries[ent.ip]; ok {
+		e.Value = ent
+		c.ll.MoveToFront(e)
+		return 0
+	}
+	c.entries[ent.ip] = c.ll.PushFront(ent)
+	evicted := 0
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back().Value.(*cacheEntry).ip)
+		evicted++
+	}
+	return evicted
+}
+
+func (c *lruCache) remove(ip string) bool {
+	e, ok := c.entries[ip]
+	if !ok {
+		return false
+	}
+	c.ll.Remove(e)
+	delete(c.entries, ip)
+	return true
+}
+
+//CachedDB read-through cache in front of a revdb backend.
+//Known ips are kept in an lru of decoded values, unknown ips in a
+//separate negative lru. Writes invalidate both.
+type CachedDB struct {
+	DBIface
+	lock    sync.Mutex
+	pos     *lruCache
+	neg     *lruCache
+	negTTL  time.Duration
+	stripes [cacheStripes]uint64
+	stats   CacheStats
+}
+
+//NewCachedDB wraps db with a cache of size entries and negSize unknown ips
+func NewCachedDB(db DBIface, size int, negSize int, negTTL time.Duration) *CachedDB {
+	return &CachedDB{
+		DBIface: db,
+		pos:     newLruCache(size),
+		neg:     newLruCache(negSize),
+		negTTL:  negTTL,
+	}
+}
+
+func stripe(ip string) int {
+	h := fnv.New32a()
+	h.Write([]byte(ip))
+	return int(h.Sum32() % cacheStripes)
+}
+
+func (c *CachedDB) String() string {
+	return "cached " + c.DBIface.String()
+}
+
+//ReadDB serves ip from cache, falling back to the backend
+func (c *CachedDB) ReadDB(ip string) (DnsVal, error) {
+	c.lock.Lock()
+	if ent, ok := c.pos.get(ip); ok {
+		c.stats.Hits++
+		c.lock.Unlock()
+		return copyDnsVal(ent.val), nil
+	}
+	if ent, ok := c.neg.get(ip); ok {
+		if time.Now().Before(ent.expires) {
+			c.stats.NegativeHits++
+			c.lock.Unlock()
+			return DnsVal{}, nil
+		}
+		c.neg.remove(ip)
+	}
+	c.stats.Misses++
+	s := stripe(ip)
+	gen := c.stripes[s]
+	c.lock.Unlock()
+
+	val, err := c.DBIface.ReadDB(ip)
+	if err != nil {
+		return val, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	//A write raced with the read, don't cache a stale value
+	if c.stripes[s] != gen {
+		return val, nil
+	}
+	if len(val.Domains) == 0 {
+		c.stats.Evictions += uint64(c.neg.add(&cacheEntry{
+			ip:      ip,
+			expires: time.Now().Add(c.negTTL),
+		}))
+	} else {
+		c.stats.Evictions += uint64(c.pos.add(&cacheEntry{
+			ip:  ip,
+			val: copyDnsVal(val),
+		}))
+	}
+	return val, nil
+}
+
+//ReadDBMulti serves cached ips and reads the rest from the backend
+//in a single transaction
+func (c *CachedDB) ReadDBMulti(ips []string, fn func(ip string, val DnsVal) error) error {
+	br, ok := c.DBIface.(BulkReader)
+	if !ok {
+		return fmt.Errorf("%s doesn't support bulk reads", c.DBIface)
+	}
+	vals := make(map[string]DnsVal, len(ips))
+	var misses []string
+	c.lock.Lock()
+	for _, ip := range ips {
+		if ent, ok := c.pos.get(ip); ok {
+			c.stats.Hits++
+			vals[ip] = copyDnsVal(ent.val)
+		} else if ent, ok := c.neg.get(ip); ok && time.Now().Before(ent.expires) {
+			c.stats.NegativeHits++
+			vals[ip] = DnsVal{}
+		} else {
+			c.stats.Misses++
+			misses = append(misses, ip)
+		}
+	}
+	c.lock.Unlock()
+
+	if len(misses) > 0 {
+		err := br.ReadDBMulti(misses, func(ip string, val DnsVal) error {
+			vals[ip] = val
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, ip := range ips {
+		if err := fn(ip, vals[ip]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//WriteDB writes through to the backend and invalidates ip
+func (c *CachedDB) WriteDB(ip string, domains []string, ev Evidence) (int, error) {
+	n, err := c.DBIface.WriteDB(ip, domains, ev)
+	c.Invalidate(ip)
+	return n, err
+}
+
+//ReadDomain reads the domain index of the backend, bypassing the cache
+func (c *CachedDB) ReadDomain(domain string) (map[string]DnsInfo, error) {
+	dr, ok := c.DBIface.(DomainReader)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't support domain lookups", c.DBIface)
+	}
+	return dr.ReadDomain(domain)
+}
+
+//ScanDB iterates the backend, bypassing the cache
+func (c *CachedDB) ScanDB(fn func(ip string, val DnsVal) error) error {
+	s, ok := c.DBIface.(DBScanner)
+	if !ok {
+		return fmt.Errorf("%s doesn't support scans", c.DBIface)
+	}
+	return s.ScanDB(fn)
+}
+
+//ScanDBAfter runs a paged scan of the backend, bypassing the cache
+func (c *CachedDB) ScanDBAfter(after string, fn func(ip string, val DnsVal) error) error {
+	s, ok := c.DBIface.(PagedScanner)
+	if !ok {
+		return fmt.Errorf("%s doesn't support paged scans", c.DBIface)
+	}
+	return s.ScanDBAfter(after, fn)
+}
+
+//LoadDB loads records into the backend and invalidates their ips
+func (c *CachedDB) LoadDB(recs []Record) error {
+	l, ok := c.DBIface.(DBLoader)
+	if !ok {
+		return fmt.Errorf("%s doesn't support loads", c.DBIface)
+	}
+	err := l.LoadDB(recs)
+	for _, rec := range recs {
+		c.Invalidate(rec.IP)
+	}
+	return err
+}
+
+//Purge purges the backend and invalidates the changed ips
+func (c *CachedDB) Purge(p Purge, dryRun bool) (PurgeResult, error) {
+	pr, ok := c.DBIface.(Purger)
+	if !ok {
+		return PurgeResult{}, fmt.Errorf("%s doesn't support purges", c.DBIface)
+	}
+	res, err := pr.Purge(p, dryRun)
+	if !dryRun {
+		for _, ip := range res.Changed {
+			c.Invalidate(ip)
+		}
+	}
+	return res, err
+}
+
+//Annotate stores an annotation in the backend
+func (c *CachedDB) Annotate(kind string, key string, a Annotation) error {
+	an, ok := c.DBIface.(Annotator)
+	if !ok {
+		return fmt.Errorf("%s doesn't support annotations", c.DBIface)
+	}
+	return an.Annotate(kind, key, a)
+}
+
+//Annotations reads annotations from the backend
+func (c *CachedDB) Annotations(kind string, keys []string) (map[string][]Annotation, error) {
+	an, ok := c.DBIface.(Annotator)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't support annotations", c.DBIface)
+	}
+	return an.Annotations(kind, keys)
+}
+
+//SearchTag searches annotations in the backend
+func (c *CachedDB) SearchTag(tag string) ([]AnnotationRef, error) {
+	an, ok := c.DBIface.(Annotator)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't support annotations", c.DBIface)
+	}
+	return an.SearchTag(tag)
+}
+
+//Invalidate drops ip from the cache
+func (c *CachedDB) Invalidate(ip string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stripes[stripe(ip)]++
+	if c.pos.remove(ip) {
+		c.stats.Invalidated++
+	}
+	if c.neg.remove(ip) {
+		c.stats.Invalidated++
+	}
+}
+
+//Stats returns a snapshot of the cache counters
+func (c *CachedDB) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stats := c.stats
+	stats.Entries = c.pos.ll.Len()
+	stats.NegEntries = c.neg.ll.Len()
+	return stats
+}
+
+//copyDnsVal deep copies v, cached values are shared by all readers and
+//must not change under filters or Observe calls on returned values
+func copyDnsVal(v DnsVal) DnsVal {
+	ret := DnsVal{
+		IpInfo:  v.IpInfo,
+		Domains: make(map[string]DnsInfo, len(v.Domains)),
+	}
+	for name, info := range v.Domains {
+		info.Sources = append([]string(nil), info.Sources...)
+		info.Sensors = append([]string(nil), info.Sensors...)
+		info.Intervals = append([]Interval(nil), info.Intervals...)
+		ret.Domains[name] = info
+	}
+	return ret
+}
@@ -0,0 +1,80 @@
+package revdb
+
+import (
+	"testing"
+	"time"
+)
+
+//memDB minimal in memory backend counting reads
+type memDB struct {
+	vals  map[string]DnsVal
+	reads int
+}
+
+func newMemDB() *memDB {
+	return &memDB{vals: make(map[string]DnsVal)}
+}
+
+func (m *memDB) ReadDB(ip string) (DnsVal, error) {
+	m.reads++
+	return m.vals[ip], nil
+}
+
+func (m *memDB) WriteDB(ip string, domains []string, ev Evidence) (int, error) {
+	val, ok := m.vals[ip]
+	if !ok {
+		val = *NewDnsVal()
+	}
+	for _, name := range domains {
+		info := val.Domains[name]
+		info.Observe(ev)
+		val.Domains[name] = info
+	}
+	m.vals[ip] = val
+	return 0, nil
+}
+
+func (m *memDB) initConfig(cfg dbConfig) {}
+func (m *memDB) Close()                  {}
+func (m *memDB) String() string          { return "memDB" }
+
+func TestCachedDB(t *testing.T) {
+	mem := newMemDB()
+	c := NewCachedDB(mem, 2, 2, time.Minute)
+
+	//negative entry is served from cache until a write
+	c.ReadDB("10.0.0.1")
+	c.ReadDB("10.0.0.1")
+	if mem.reads != 1 {
+		t.Errorf("Negative cache miss: %d reads", mem.reads)
+	}
+	c.WriteDB("10.0.0.1", []string{"abc.com"}, Evidence{Source: SourceDNS})
+	val, _ := c.ReadDB("10.0.0.1")
+	if len(val.Domains) != 1 {
+		t.Errorf("Stale read after write: %v", val)
+	}
+	c.ReadDB("10.0.0.1")
+	if mem.reads != 2 {
+		t.Errorf("Cache miss: %d reads", mem.reads)
+	}
+
+	//returned values don't share slices with the cache
+	info := val.Domains["abc.com"]
+	info.Sources[0] = SourceHTTP
+	if val, _ = c.ReadDB("10.0.0.1"); val.Domains["abc.com"].Sources[0] != SourceDNS {
+		t.Errorf("Cached value changed by caller: %v", val)
+	}
+
+	//lru eviction
+	c.WriteDB("10.0.0.2", []string{"abc.com"}, Evidence{})
+	c.WriteDB("10.0.0.3", []string{"abc.com"}, Evidence{})
+	c.ReadDB("10.0.0.2")
+	c.ReadDB("10.0.0.3")
+	stats := c.Stats()
+	if stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("Invalid stats: %+v", stats)
+	}
+	if stats.Hits != 2 || stats.NegativeHits != 1 {
+		t.Errorf("Invalid stats: %+v", stats)
+	}
+}
@@ -0,0 +1,292 @@
+package revdb
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+//Binary values are magic, version and flags bytes followed by the
+//payload, flate compressed if flagCompressed is set. The payload holds
+//the ipinfo bits, a string table and the domains. Strings (domains,
+//sources, sensors, whois) are stored once in the table and referenced
+//by index.
+const (
+	valueMagic     = 0xb1
+	valueVersion   = 1
+	flagCompressed = 1 << 0
+)
+
+const (
+	ipBlack = 1 << iota
+	ipAttacker
+	ipShared
+)
+
+var errBadValue = errors.New("revdb: corrupt value")
+
+//Encoding of values stored in bolt. Values are JSON unless Binary is
+//set, binary values larger than CompressAbove bytes are compressed.
+type Encoding struct {
+	Binary        bool
+	CompressAbove int
+}
+
+//EncodeValue serializes val with enc
+func EncodeValue(val *DnsVal, enc Encoding) ([]byte, error) {
+	if !enc.Binary {
+		stored := *val
+		stored.Annotations = nil
+		return json.Marshal(&stored)
+	}
+	payload := encodeBinary(val)
+	flags := byte(0)
+	if enc.CompressAbove > 0 && len(payload) > enc.CompressAbove {
+		var buf bytes.Buffer
+		zw, err := flate.NewWriter(&buf, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		zw.Write(payload)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(payload) {
+			payload = buf.Bytes()
+			flags |= flagCompressed
+		}
+	}
+	out := make([]byte, 0, len(payload)+3)
+	out = append(out, valueMagic, valueVersion, flags)
+	return append(out, payload...), nil
+}
+
+//DecodeValue parses a stored value, binary or legacy JSON
+func DecodeValue(data []byte) (DnsVal, error) {
+	val := NewDnsVal()
+	if len(data) == 0 {
+		return *val, errBadValue
+	}
+	if data[0] != valueMagic {
+		err := json.Unmarshal(data, val)
+		return *val, err
+	}
+	if len(data) < 3 {
+		return *val, errBadValue
+	}
+	if data[1] != valueVersion {
+		return *val, fmt.Errorf("revdb: unsupported value version %d", data[1])
+	}
+	payload := data[3:]
+	if data[2]&flagCompressed != 0 {
+		zr := flate.NewReader(bytes.NewReader(payload))
+		defer zr.Close()
+		var err error
+		if payload, err = ioutil.ReadAll(zr); err != nil {
+			return *val, err
+		}
+	}
+	err := decodeBinary(payload, val)
+	return *val, err
+}
+
+type strTable struct {
+	idx  map[string]uint64
+	strs []string
+}
+
+func (t *strTable) intern(s string) uint64 {
+	if i, ok := t.idx[s]; ok {
+		return i
+	}
+	i := uint64(len(t.strs))
+	t.idx[s] = i
+	t.strs = append(t.strs, s)
+	return i
+}
+
+type encoder struct {
+	buf []byte
+	tmp [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.tmp[:], v)
+	e.buf = append(e.buf, e.tmp[:n]...)
+}
+
+func (e *encoder) varint(v int64) {
+	n := binary.PutVarint(e.tmp[:], v)
+	e.buf = append(e.buf, e.tmp[:n]...)
+}
+
+func (e *encoder) set(t *strTable, vals []string) {
+	e.uvarint(uint64(len(vals)))
+	for _, v := range vals {
+		e.uvarint(t.intern(v))
+	}
+}
+
+func encodeBinary(val *DnsVal) []byte {
+	names := make([]string, 0, len(val.Domains))
+	for name := range val.Domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	t := &strTable{idx: make(map[string]uint64)}
+	body := &encoder{}
+	body.uvarint(uint64(len(names)))
+	for _, name := range names {
+		info := val.Domains[name]
+		body.uvarint(t.intern(name))
+		body.varint(int64(info.WlId))
+		body.uvarint(t.intern(info.Whois))
+		body.set(t, info.Sources)
+		body.set(t, info.Sensors)
+		body.uvarint(uint64(info.Count))
+		body.varint(info.FirstSeen)
+		body.varint(info.LastSeen - info.FirstSeen)
+		body.uvarint(uint64(len(info.Intervals)))
+		prev := info.FirstSeen
+		for _, iv := range info.Intervals {
+			body.varint(iv.From - prev)
+			body.varint(iv.To - iv.From)
+			prev = iv.To
+		}
+	}
+
+	var bits uint64
+	if val.Black {
+		bits |= ipBlack
+	}
+	if val.Attacker {
+		bits |= ipAttacker
+	}
+	if val.Shared {
+		bits |= ipShared
+	}
+	out := &encoder{}
+	out.uvarint(bits)
+	out.uvarint(uint64(len(t.strs)))
+	for _, s := range t.strs {
+		out.uvarint(uint64(len(s)))
+		out.buf = append(out.buf, s...)
+	}
+	return append(out.buf, body.buf...)
+}
+
+type decoder struct {
+	buf  []byte
+	strs []string
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errBadValue
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errBadValue
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) str() string {
+	i := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if i >= uint64(len(d.strs)) {
+		d.err = errBadValue
+		return ""
+	}
+	return d.strs[i]
+}
+
+func (d *decoder) set() []string {
+	n := d.uvarint()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errBadValue
+		return nil
+	}
+	vals := make([]string, n)
+	for i := range vals {
+		vals[i] = d.str()
+	}
+	return vals
+}
+
+func decodeBinary(data []byte, val *DnsVal) error {
+	d := &decoder{buf: data}
+	bits := d.uvarint()
+	val.Black = bits&ipBlack != 0
+	val.Attacker = bits&ipAttacker != 0
+	val.Shared = bits&ipShared != 0
+
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		return errBadValue
+	}
+	d.strs = make([]string, 0, n)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		l := d.uvarint()
+		if l > uint64(len(d.buf)) {
+			return errBadValue
+		}
+		d.strs = append(d.strs, string(d.buf[:l]))
+		d.buf = d.buf[l:]
+	}
+
+	n = d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		name := d.str()
+		info := DnsInfo{
+			WlId:    int(d.varint()),
+			Whois:   d.str(),
+			Sources: d.set(),
+			Sensors: d.set(),
+			Count:   int(d.uvarint()),
+		}
+		info.FirstSeen = d.varint()
+		info.LastSeen = info.FirstSeen + d.varint()
+		if nIv := d.uvarint(); nIv > 0 && nIv <= uint64(len(d.buf)) {
+			info.Intervals = make([]Interval, nIv)
+			prev := info.FirstSeen
+			for j := range info.Intervals {
+				from := prev + d.varint()
+				to := from + d.varint()
+				info.Intervals[j] = Interval{From: from, To: to}
+				prev = to
+			}
+		} else if nIv > 0 {
+			return errBadValue
+		}
+		val.Domains[name] = info
+	}
+	return d.err
+}
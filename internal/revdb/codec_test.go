@@ -0,0 +1,66 @@
+package revdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func testVal(n int) *DnsVal {
+	val := NewDnsVal()
+	val.Shared = true
+	for i := 0; i < n; i++ {
+		val.Domains[fmt.Sprintf("host%d.example.com", i)] = DnsInfo{
+			WlId:      i,
+			Sources:   []string{SourceDNS, SourceSSL},
+			Sensors:   []string{"sensor1"},
+			Count:     i + 1,
+			FirstSeen: 1550000000,
+			LastSeen:  1550000000 + int64(i),
+			Intervals: []Interval{{From: 1550000000, To: 1550000000 + int64(i)}},
+		}
+	}
+	return val
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, enc := range []Encoding{
+		{},
+		{Binary: true},
+		{Binary: true, CompressAbove: 64},
+	} {
+		val := testVal(50)
+		data, err := EncodeValue(val, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeValue(data)
+		if err != nil {
+			t.Fatalf("%+v: %s", enc, err)
+		}
+		if !reflect.DeepEqual(*val, got) {
+			t.Errorf("%+v: round trip mismatch", enc)
+		}
+	}
+}
+
+func TestCodecLegacyJSON(t *testing.T) {
+	data := []byte(`{"Black":false,"Attacker":false,"Domains":{"abc.com":{"WlId":12,"Whois":""}}}`)
+	val, err := DecodeValue(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Domains["abc.com"].WlId != 12 {
+		t.Errorf("Invalid legacy decode: %v", val)
+	}
+
+	bin, _ := EncodeValue(testVal(50), Encoding{Binary: true})
+	js, _ := json.Marshal(testVal(50))
+	if len(bin) >= len(js) {
+		t.Errorf("Binary value not smaller: %d >= %d", len(bin), len(js))
+	}
+	if _, err := DecodeValue(bin[:len(bin)/2]); err == nil {
+		t.Error("Truncated value decoded")
+	}
+}
@@ -0,0 +1,202 @@
+package revdb
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+//The domain index maps normalized domain\x00ip keys to nothing, it is
+//kept in step with the ip values and rebuilt from them when missing.
+const domainBucket = "domainIndex"
+
+//indexVersion of the index key format, indexes of older versions are
+//rebuilt. Version 2 normalizes names.
+const indexVersion = "2"
+
+//DomainReader looks up the ips a domain resolved to
+type DomainReader interface {
+	//ReadDomain returns the pair info of every ip seen for domain
+	ReadDomain(domain string) (map[string]DnsInfo, error)
+}
+
+//Mapping a newly observed ip or ip/domain pair
+type Mapping struct {
+	IP     string `json:"ip"`
+	Domain string `json:"domain"`
+	//NewIP the ip wasn't known before
+	NewIP  bool   `json:"new_ip"`
+	Source string `json:"source"`
+	Sensor string `json:"sensor,omitempty"`
+	WlId   int    `json:"wlid"`
+	Time   int64  `json:"time"`
+}
+
+func domainKey(domain string, ip string) []byte {
+	return []byte(NormalizeName(domain) + "\x00" + ip)
+}
+
+//indexKeys returns the index keys of the domains of ip
+func indexKeys(ip string, domains map[string]DnsInfo) map[string]bool {
+	keys := make(map[string]bool, len(domains))
+	for name := range domains {
+		keys[string(domainKey(name, ip))] = true
+	}
+	return keys
+}
+
+//reindex updates the index of ip from the domains it had before to the
+//ones of val and returns the names that were added
+func reindex(tx *bolt.Tx, ip string, before map[string]DnsInfo, val *DnsVal) ([]string, error) {
+	idx := tx.Bucket([]byte(domainBucket))
+	var added []string
+	for name := range val.Domains {
+		if _, ok := before[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	//names differing only in case share a key
+	oldKeys, newKeys := indexKeys(ip, before), indexKeys(ip, val.Domains)
+	for key := range newKeys {
+		if oldKeys[key] {
+			continue
+		}
+		if err := idx.Put([]byte(key), []byte{}); err != nil {
+			return nil, err
+		}
+	}
+	for key := range oldKeys {
+		if newKeys[key] {
+			continue
+		}
+		if err := idx.Delete([]byte(key)); err != nil {
+			return nil, err
+		}
+	}
+	return added, nil
+}
+
+//indexBatch ips indexed per transaction when building the index
+const indexBatch = 10000
+
+//Config keys of the index, the version of its keys and the next ip to
+//index while it is being built. Builds resume from it after restarts.
+var (
+	indexVersionKey = []byte("domainIndexVersion")
+	indexFrom       = []byte("domainIndexFrom")
+)
+
+//buildDomainIndex creates the index of a db written before it existed
+//or with an older version, in transactions of indexBatch ips
+func buildDomainIndex(db *bolt.DB) error {
+	var from []byte
+	err := db.Update(func(tx *bolt.Tx) error {
+		cfg, err := tx.CreateBucketIfNotExists([]byte("config"))
+		if err != nil {
+			return err
+		}
+		if tx.Bucket([]byte(domainBucket)) != nil {
+			if string(cfg.Get(indexVersionKey)) == indexVersion {
+				if v := cfg.Get(indexFrom); v != nil {
+					from = append([]byte{}, v...)
+				}
+				return nil
+			}
+			log.Println("Rebuilding the domain index")
+			if err := tx.DeleteBucket([]byte(domainBucket)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte(domainBucket)); err != nil {
+			return err
+		}
+		if err := cfg.Put(indexVersionKey, []byte(indexVersion)); err != nil {
+			return err
+		}
+		//keys are ips, 0 sorts before all of them
+		from = []byte{0}
+		return cfg.Put(indexFrom, from)
+	})
+	if err != nil || from == nil {
+		return err
+	}
+
+	n := 0
+	for from != nil {
+		err := db.Update(func(tx *bolt.Tx) error {
+			idx := tx.Bucket([]byte(domainBucket))
+			c := tx.Bucket([]byte(bucketName)).Cursor()
+			k, v := c.Seek(from)
+			for i := 0; k != nil && i < indexBatch; k, v = c.Next() {
+				i++
+				n++
+				val, err := DecodeValue(v)
+				if err != nil {
+					log.Printf("Error decoding val for %s: %s", k, err)
+					continue
+				}
+				for name := range val.Domains {
+					if err := idx.Put(domainKey(name, string(k)), []byte{}); err != nil {
+						return err
+					}
+				}
+			}
+			cfg := tx.Bucket([]byte("config"))
+			if k == nil {
+				from = nil
+				return cfg.Delete(indexFrom)
+			}
+			from = append([]byte{}, k...)
+			return cfg.Put(indexFrom, from)
+		})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("Indexed domains of %d ips\n", n)
+		}
+	}
+	return nil
+}
+
+//ReadDomain returns the ips domain was seen on in a single transaction.
+//Names are matched normalized, the infos of names differing only in case
+//are merged.
+func (b *BoltDB) ReadDomain(domain string) (map[string]DnsInfo, error) {
+	domain = NormalizeName(domain)
+	ret := make(map[string]DnsInfo)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		idx := tx.Bucket([]byte(domainBucket))
+		if idx == nil {
+			return nil
+		}
+		bkt := tx.Bucket([]byte(bucketName))
+		prefix := []byte(domain + "\x00")
+		c := idx.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			ip := string(k[len(prefix):])
+			v := bkt.Get([]byte(ip))
+			if v == nil {
+				continue
+			}
+			val, err := DecodeValue(v)
+			if err != nil {
+				log.Printf("Error decoding val for %s: %s", ip, err)
+				continue
+			}
+			for name, info := range val.Domains {
+				if NormalizeName(name) != domain {
+					continue
+				}
+				if merged, ok := ret[ip]; ok {
+					b.limits.merge(&merged, info)
+					info = merged
+				}
+				ret[ip] = info
+			}
+		}
+		return nil
+	})
+	return ret, err
+}
@@ -0,0 +1,196 @@
+package revdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+//importBatch records loaded per transaction
+const importBatch = 1000
+
+//Record one ip and its value, a line of an NDJSON dump
+type Record struct {
+	IP string `json:"ip"`
+	DnsVal
+}
+
+//DBScanner iterates over every ip of a backend
+type DBScanner interface {
+	ScanDB(fn func(ip string, val DnsVal) error) error
+}
+
+//PagedScanner iterates over the ips sorting after a key, for scans
+//resumed across requests
+type PagedScanner interface {
+	ScanDBAfter(after string, fn func(ip string, val DnsVal) error) error
+}
+
+//DBLoader merges complete records into a backend
+type DBLoader interface {
+	LoadDB(recs []Record) error
+}
+
+//ExportFilter selects the records of a dump. Empty fields match all.
+type ExportFilter struct {
+	Nets     []*net.IPNet
+	Suffix   string
+	From, To int64
+}
+
+//MatchSuffix reports whether name is suffix or a subdomain of it
+func MatchSuffix(name string, suffix string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	suffix = strings.ToLower(strings.Trim(suffix, "."))
+	return name == suffix || strings.HasSuffix(name, "."+suffix)
+}
+
+//ipIn reports whether ip is in any of nets
+func ipIn(ip string, nets []*net.IPNet) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+//apply returns val narrowed to the filter and whether anything is left
+func (f ExportFilter) apply(ip string, val DnsVal) (DnsVal, bool) {
+	if len(f.Nets) > 0 && !ipIn(ip, f.Nets) {
+		return val, false
+	}
+	if f.Suffix == "" && f.From == 0 && f.To == 0 {
+		return val, true
+	}
+	to := f.To
+	if to == 0 {
+		to = 1<<63 - 1
+	}
+	val = val.Filter(func(name string, info DnsInfo) bool {
+		if f.Suffix != "" && !MatchSuffix(name, f.Suffix) {
+			return false
+		}
+		if (f.From != 0 || f.To != 0) && !info.SeenBetween(f.From, to) {
+			return false
+		}
+		return true
+	})
+	return val, len(val.Domains) > 0
+}
+
+//Export writes one JSON record per ip matching f to w
+func Export(db DBScanner, w io.Writer, f ExportFilter) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	count := 0
+	err := db.ScanDB(func(ip string, val DnsVal) error {
+		val, ok := f.apply(ip, val)
+		if !ok {
+			return nil
+		}
+		count++
+		return enc.Encode(Record{IP: ip, DnsVal: val})
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+//Import loads an NDJSON dump from r into db, merging with existing values.
+//Backends that can't load records get them replayed as observations.
+func Import(r io.Reader, db DBWriter) (int, error) {
+	load := replay(db)
+	if loader, ok := db.(DBLoader); ok {
+		load = loader.LoadDB
+	}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	count := 0
+	batch := make([]Record, 0, importBatch)
+	for {
+		rec := Record{DnsVal: *NewDnsVal()}
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("record %d: %s", count+1, err)
+		}
+		if net.ParseIP(rec.IP) == nil {
+			return count, fmt.Errorf("record %d: invalid ip %q", count+1, rec.IP)
+		}
+		batch = append(batch, rec)
+		count++
+		if len(batch) == importBatch {
+			if err := load(batch); err != nil {
+				return count, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		return count, load(batch)
+	}
+	return count, nil
+}
+
+//replay loads records through WriteDB, one observation per source and
+//sensor at the first and last seen times. Counts, intervals and ip flags
+//are rebuilt by the backend.
+func replay(db DBWriter) func(recs []Record) error {
+	return func(recs []Record) error {
+		for _, rec := range recs {
+			for name, info := range rec.Domains {
+				for _, ev := range replayEvidence(info) {
+					if _, err := db.WriteDB(rec.IP, []string{name}, ev); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+}
+
+func replayEvidence(info DnsInfo) []Evidence {
+	var first, last time.Time
+	if info.FirstSeen != 0 {
+		first = time.Unix(info.FirstSeen, 0)
+	}
+	if info.LastSeen != 0 {
+		last = time.Unix(info.LastSeen, 0)
+	}
+	n := len(info.Sources)
+	if len(info.Sensors) > n {
+		n = len(info.Sensors)
+	}
+	if n == 0 {
+		n = 1
+	}
+	var evs []Evidence
+	for i := 0; i < n; i++ {
+		ev := Evidence{Ts: first}
+		if i < len(info.Sources) {
+			ev.Source = info.Sources[i]
+		}
+		if i < len(info.Sensors) {
+			ev.Sensor = info.Sensors[i]
+		}
+		evs = append(evs, ev)
+	}
+	if !last.Equal(first) {
+		ev := evs[0]
+		ev.Ts = last
+		evs = append(evs, ev)
+	}
+	return evs
+}
@@ -0,0 +1,78 @@
+package revdb
+
+import (
+	"math"
+	"sort"
+)
+
+//Interval unix times a pair was continuously observed
+type Interval struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+//intervals of the pair, pairs recorded before intervals existed
+//are covered by their first/last seen times
+func (d DnsInfo) intervals() []Interval {
+	if len(d.Intervals) == 0 && d.FirstSeen != 0 {
+		return []Interval{{From: d.FirstSeen, To: d.LastSeen}}
+	}
+	return d.Intervals
+}
+
+//SeenAt reports whether the pair was valid at unix time t
+func (d DnsInfo) SeenAt(t int64) bool {
+	return d.SeenBetween(t, t)
+}
+
+//SeenBetween reports whether the pair was valid at any time in [from, to]
+func (d DnsInfo) SeenBetween(from, to int64) bool {
+	for _, iv := range d.intervals() {
+		if iv.From <= to && iv.To >= from {
+			return true
+		}
+	}
+	return false
+}
+
+//addInterval records an observation at ts. Observations within gap of
+//an interval extend it, at most max intervals are kept.
+func (d *DnsInfo) addInterval(ts int64, gap int64, max int) {
+	d.Intervals = mergeIntervals(append(d.intervals(), Interval{From: ts, To: ts}), gap, max)
+}
+
+//mergeIntervals sorts ivs and joins intervals less than gap apart. Over
+//max intervals the closest neighbours are merged.
+func mergeIntervals(ivs []Interval, gap int64, max int) []Interval {
+	if len(ivs) == 0 {
+		return nil
+	}
+	sort.Slice(ivs, func(i, j int) bool {
+		return ivs[i].From < ivs[j].From
+	})
+
+	merged := ivs[:1]
+	for _, iv := range ivs[1:] {
+		last := &merged[len(merged)-1]
+		if iv.From-last.To <= gap {
+			if iv.To > last.To {
+				last.To = iv.To
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+
+	for max > 0 && len(merged) > max {
+		closest := 0
+		var minGap int64 = math.MaxInt64
+		for i := 0; i < len(merged)-1; i++ {
+			if g := merged[i+1].From - merged[i].To; g < minGap {
+				closest, minGap = i, g
+			}
+		}
+		merged[closest].To = merged[closest+1].To
+		merged = append(merged[:closest+1], merged[closest+2:]...)
+	}
+	return merged
+}
@@ -0,0 +1,49 @@
+package revdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIntervals(t *testing.T) {
+	l := Limits{IntervalGap: time.Hour, MaxIntervals: 2}
+	at := func(ts int64) Evidence {
+		return Evidence{Ts: time.Unix(ts, 0)}
+	}
+
+	var info DnsInfo
+	l.observe(&info, at(1000))
+	l.observe(&info, at(2000))
+	l.observe(&info, at(100000))
+	if len(info.Intervals) != 2 {
+		t.Fatalf("Invalid intervals: %v", info.Intervals)
+	}
+	if !info.SeenAt(1500) || info.SeenAt(50000) || !info.SeenAt(100000) {
+		t.Errorf("Invalid as-of: %v", info.Intervals)
+	}
+	if !info.SeenBetween(50000, 200000) || info.SeenBetween(3000, 4000) {
+		t.Errorf("Invalid window: %v", info.Intervals)
+	}
+	if info.FirstSeen != 1000 || info.LastSeen != 100000 || info.Count != 3 {
+		t.Errorf("Invalid seen times: %+v", info)
+	}
+
+	//out of order observation merges, cap merges closest intervals
+	l.observe(&info, at(50000))
+	if len(info.Intervals) != 2 || !info.SeenAt(50000) {
+		t.Errorf("Invalid merge: %v", info.Intervals)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	ts, err := ParseTime("1700000000.25")
+	if err != nil || ts.Unix() != 1700000000 || ts.Nanosecond() != 250000000 {
+		t.Errorf("Invalid epoch: %v %v", ts, err)
+	}
+	if _, err := ParseTime("1700000000000"); err == nil {
+		t.Errorf("Millisecond epoch accepted")
+	}
+	if ts, err = ParseTime("2019-03-01T00:00:00Z"); err != nil || ts.Unix() != 1551398400 {
+		t.Errorf("Invalid RFC3339 time: %v %v", ts, err)
+	}
+}
@@ -0,0 +1,155 @@
+package revdb
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//Eviction policies for ips over the domain cap
+const (
+	//EvictLRS drops the least recently seen domains
+	EvictLRS = "lrs"
+	//EvictCount drops the least observed domains
+	EvictCount = "count"
+)
+
+//Limits bounds the domains kept per ip, MaxDomains 0 means no cap.
+//Observations of a pair closer than IntervalGap extend the same
+//validity interval, at most MaxIntervals are kept per pair.
+type Limits struct {
+	MaxDomains   int
+	Eviction     string
+	IntervalGap  time.Duration
+	MaxIntervals int
+}
+
+//Validate checks the eviction policy
+func (l Limits) Validate() error {
+	switch l.Eviction {
+	case "", EvictLRS, EvictCount:
+		return nil
+	}
+	return fmt.Errorf("unknown eviction policy %q", l.Eviction)
+}
+
+//observe records ev for the pair
+func (l Limits) observe(info *DnsInfo, ev Evidence) {
+	info.addInterval(ev.unix(), int64(l.IntervalGap/time.Second), l.MaxIntervals)
+	info.Observe(ev)
+}
+
+//merge folds src, observed elsewhere, into dst
+func (l Limits) merge(dst *DnsInfo, src DnsInfo) {
+	ivs := append(dst.intervals(), src.intervals()...)
+	dst.Intervals = mergeIntervals(ivs, int64(l.IntervalGap/time.Second), l.MaxIntervals)
+	for _, s := range src.Sources {
+		dst.Sources = addToSet(dst.Sources, s)
+	}
+	for _, s := range src.Sensors {
+		dst.Sensors = addToSet(dst.Sensors, s)
+	}
+	dst.Count += src.Count
+	if src.FirstSeen != 0 && (dst.FirstSeen == 0 || src.FirstSeen < dst.FirstSeen) {
+		dst.FirstSeen = src.FirstSeen
+	}
+	if src.LastSeen > dst.LastSeen {
+		dst.LastSeen = src.LastSeen
+	}
+	if src.WlId != 0 {
+		dst.WlId = src.WlId
+	}
+	if src.Whois != "" {
+		dst.Whois = src.Whois
+	}
+}
+
+//mergeVal folds the ip value src into dst
+func (l Limits) mergeVal(dst *DnsVal, src DnsVal) {
+	dst.Black = dst.Black || src.Black
+	dst.Attacker = dst.Attacker || src.Attacker
+	dst.Shared = dst.Shared || src.Shared
+	for name, info := range src.Domains {
+		cur, ok := dst.Domains[name]
+		if !ok {
+			dst.Domains[name] = info
+			continue
+		}
+		l.merge(&cur, info)
+		dst.Domains[name] = cur
+	}
+	l.apply(dst)
+}
+
+//apply evicts domains over the cap and flags the ip as shared
+func (l Limits) apply(val *DnsVal) {
+	if l.MaxDomains <= 0 || len(val.Domains) <= l.MaxDomains {
+		return
+	}
+	val.Shared = true
+	less := lessRecent
+	if l.Eviction == EvictCount {
+		less = lessCount
+	}
+	names := val.ordered(less)
+	for _, name := range names[l.MaxDomains:] {
+		delete(val.Domains, name)
+	}
+}
+
+type domainLess func(a, b DnsInfo) bool
+
+//lessRecent orders by last seen, then count
+func lessRecent(a, b DnsInfo) bool {
+	if a.LastSeen != b.LastSeen {
+		return a.LastSeen > b.LastSeen
+	}
+	return a.Count > b.Count
+}
+
+//lessCount orders by count, then last seen
+func lessCount(a, b DnsInfo) bool {
+	if a.Count != b.Count {
+		return a.Count > b.Count
+	}
+	return a.LastSeen > b.LastSeen
+}
+
+//ordered returns domain names best first
+func (v DnsVal) ordered(less domainLess) []string {
+	names := make([]string, 0, len(v.Domains))
+	for name := range v.Domains {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := v.Domains[names[i]], v.Domains[names[j]]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+//Ranked returns domain names ordered by observation count, then recency
+func (v DnsVal) Ranked() []string {
+	return v.ordered(lessCount)
+}
+
+//Top returns a copy of the value holding the n best ranked domains
+func (v DnsVal) Top(n int) DnsVal {
+	if n <= 0 || len(v.Domains) <= n {
+		return v
+	}
+	top := make(map[string]struct{}, n)
+	for _, name := range v.Ranked()[:n] {
+		top[name] = struct{}{}
+	}
+	return v.Filter(func(name string, info DnsInfo) bool {
+		_, ok := top[name]
+		return ok
+	})
+}
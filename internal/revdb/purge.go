@@ -0,0 +1,268 @@
+package revdb
+
+import (
+	"bytes"
+	"errors"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+//Purge selects the pairs removed by a purge. Set fields must all match,
+//at least one selector is required.
+type Purge struct {
+	//pairs of this ip only
+	IP string `json:"ip,omitempty"`
+	//pairs of this domain, and of its subdomains with Suffix
+	Domain string `json:"domain,omitempty"`
+	Suffix bool   `json:"suffix,omitempty"`
+	//pairs seen from Source, the source is removed from pairs also
+	//seen from others
+	Source string `json:"source,omitempty"`
+	//pairs first seen in the window, unix seconds, To 0 is open ended
+	From int64 `json:"from,omitempty"`
+	To   int64 `json:"to,omitempty"`
+}
+
+//PurgeResult counts what a purge removed, or would remove on a dry run
+type PurgeResult struct {
+	IPs int `json:"ips"`
+	//pairs removed
+	Pairs int `json:"pairs"`
+	//pairs that only lost the purged source
+	Updated int `json:"updated"`
+	//ips left without domains and deleted
+	Deleted int `json:"deleted"`
+	//ips the purge changed
+	Changed []string `json:"-"`
+}
+
+//Purger removes polluted pairs, annotations are never touched
+type Purger interface {
+	Purge(p Purge, dryRun bool) (PurgeResult, error)
+}
+
+var errNoSelector = errors.New("purge needs an ip, domain, source or time window")
+
+func (p Purge) timed() bool {
+	return p.From != 0 || p.To != 0
+}
+
+func (p Purge) matchDomain(name string) bool {
+	if p.Domain == "" {
+		return true
+	}
+	if p.Suffix {
+		return MatchSuffix(name, p.Domain)
+	}
+	//values written before names were normalized keep their case
+	return NormalizeName(name) == p.Domain
+}
+
+func (p Purge) matchTime(info DnsInfo) bool {
+	if !p.timed() {
+		return true
+	}
+	return info.FirstSeen >= p.From && (p.To == 0 || info.FirstSeen <= p.To)
+}
+
+//apply purges the pairs of val and returns how many were removed and
+//how many lost the purged source
+func (p Purge) apply(val *DnsVal) (int, int) {
+	removed, updated := 0, 0
+	for name, info := range val.Domains {
+		if !p.matchDomain(name) || !p.matchTime(info) {
+			continue
+		}
+		if p.Source != "" {
+			if !info.HasSource(p.Source) {
+				continue
+			}
+			if len(info.Sources) > 1 {
+				var rest []string
+				for _, s := range info.Sources {
+					if s != p.Source {
+						rest = append(rest, s)
+					}
+				}
+				info.Sources = rest
+				val.Domains[name] = info
+				updated++
+				continue
+			}
+		}
+		delete(val.Domains, name)
+		removed++
+	}
+	return removed, updated
+}
+
+//purgeBatch ips purged per write transaction
+const purgeBatch = 1000
+
+//candidates returns the ips a purge has to look at, nil for all
+func (p Purge) candidates(tx *bolt.Tx) []string {
+	if p.IP != "" {
+		return []string{p.IP}
+	}
+	if p.Domain == "" || p.Suffix {
+		return nil
+	}
+	ips := []string{}
+	prefix := []byte(p.Domain + "\x00")
+	c := tx.Bucket([]byte(domainBucket)).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		ips = append(ips, string(k[len(prefix):]))
+	}
+	return ips
+}
+
+//purgeChange a purged value and the domains it had before
+type purgeChange struct {
+	ip     string
+	before map[string]DnsInfo
+	val    DnsVal
+}
+
+//visit purges the value of ip, counting the purge in res. It returns
+//false for values the purge doesn't change.
+func (p Purge) visit(ip string, v []byte, res *PurgeResult) (purgeChange, bool) {
+	val, err := DecodeValue(v)
+	if err != nil {
+		log.Printf("Error decoding val for %s: %s", ip, err)
+		return purgeChange{}, false
+	}
+	before := copyDnsVal(val).Domains
+	removed, updated := p.apply(&val)
+	if removed == 0 && updated == 0 {
+		return purgeChange{}, false
+	}
+	res.IPs++
+	res.Pairs += removed
+	res.Updated += updated
+	if len(val.Domains) == 0 {
+		res.Deleted++
+	}
+	return purgeChange{ip: ip, before: before, val: val}, true
+}
+
+//writePurge stores purged values, deleting the ones left without domains
+func (b *BoltDB) writePurge(tx *bolt.Tx, changes []purgeChange) error {
+	bkt := tx.Bucket([]byte(bucketName))
+	for _, c := range changes {
+		if _, err := reindex(tx, c.ip, c.before, &c.val); err != nil {
+			return err
+		}
+		if len(c.val.Domains) == 0 {
+			if err := bkt.Delete([]byte(c.ip)); err != nil {
+				return err
+			}
+			b.numEntries--
+			continue
+		}
+		data, err := EncodeValue(&c.val, b.encoding)
+		if err != nil {
+			return err
+		}
+		if err := bkt.Put([]byte(c.ip), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Purge removes the selected pairs, ips left without domains are deleted.
+//It writes in transactions of purgeBatch ips so large purges don't block
+//writers, a dry run only counts in one read transaction.
+func (b *BoltDB) Purge(p Purge, dryRun bool) (PurgeResult, error) {
+	var res PurgeResult
+	p.Domain = NormalizeName(p.Domain)
+	if p.IP == "" && p.Domain == "" && p.Source == "" && !p.timed() {
+		return res, errNoSelector
+	}
+	if dryRun {
+		err := b.db.View(func(tx *bolt.Tx) error {
+			bkt := tx.Bucket([]byte(bucketName))
+			if ips := p.candidates(tx); ips != nil {
+				for _, ip := range ips {
+					if v := bkt.Get([]byte(ip)); v != nil {
+						p.visit(ip, v, &res)
+					}
+				}
+				return nil
+			}
+			c := bkt.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				p.visit(string(k), v, &res)
+			}
+			return nil
+		})
+		if err != nil {
+			return PurgeResult{}, err
+		}
+		return res, nil
+	}
+
+	var ips []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		ips = p.candidates(tx)
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	//full scans resume from the key after the last batch
+	from := []byte{0}
+	for from != nil {
+		var part PurgeResult
+		var changes []purgeChange
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			part, changes = PurgeResult{}, nil
+			bkt := tx.Bucket([]byte(bucketName))
+			visit := func(ip string, v []byte) {
+				if c, ok := p.visit(ip, v, &part); ok {
+					changes = append(changes, c)
+				}
+			}
+			if ips != nil {
+				n := purgeBatch
+				if n > len(ips) {
+					n = len(ips)
+				}
+				for _, ip := range ips[:n] {
+					if v := bkt.Get([]byte(ip)); v != nil {
+						visit(ip, v)
+					}
+				}
+				if ips = ips[n:]; len(ips) == 0 {
+					from = nil
+				}
+			} else {
+				c := bkt.Cursor()
+				k, v := c.Seek(from)
+				for i := 0; k != nil && i < purgeBatch; k, v = c.Next() {
+					visit(string(k), v)
+					i++
+				}
+				from = nil
+				if k != nil {
+					from = append([]byte{}, k...)
+				}
+			}
+			//written after reading, bolt cursors don't survive updates
+			return b.writePurge(tx, changes)
+		})
+		if err != nil {
+			//earlier batches are committed
+			return res, err
+		}
+		res.IPs += part.IPs
+		res.Pairs += part.Pairs
+		res.Updated += part.Updated
+		res.Deleted += part.Deleted
+		for _, c := range changes {
+			res.Changed = append(res.Changed, c.ip)
+		}
+	}
+	return res, nil
+}
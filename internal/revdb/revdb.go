@@ -2,22 +2,61 @@ package revdb
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gviz/revDNS/internal/wl"
 )
 
+//Evidence source types
+const (
+	SourceDNS  = "dns"
+	SourceSSL  = "ssl"
+	SourceHTTP = "http"
+)
+
 type DnsInfo struct {
-	WlId  int    `json:wlid`
-	Whois string `json:whois`
+	WlId    int      `json:wlid`
+	Whois   string   `json:whois`
+	Sources []string `json:"sources,omitempty"`
+	Sensors []string `json:"sensors,omitempty"`
+	//Observation count and unix times of first/last observation
+	Count     int   `json:"count,omitempty"`
+	FirstSeen int64 `json:"first_seen,omitempty"`
+	LastSeen  int64 `json:"last_seen,omitempty"`
+	//Validity intervals for as-of queries
+	Intervals []Interval `json:"intervals,omitempty"`
+}
+
+//Evidence describes where an ip/domain observation came from.
+//DNS answers are authoritative, SNI and Host headers are client supplied.
+type Evidence struct {
+	Source string
+	Sensor string
+	//Observation time, zero means now
+	Ts time.Time
+}
+
+//Time of the observation in unix seconds
+func (ev Evidence) unix() int64 {
+	if ev.Ts.IsZero() {
+		return time.Now().Unix()
+	}
+	return ev.Ts.Unix()
 }
 
 type IpInfo struct {
 	Black    bool `json:black`
 	Attacker bool `json:attacker`
+	//Shared ip hit the per ip domain cap (CDN, shared hosting)
+	Shared bool `json:"shared,omitempty"`
 }
 type DnsVal struct {
 	IpInfo
 	Domains map[string]DnsInfo
+	//Analyst annotations of the ip and its domains, filled in on
+	//lookups and never stored with the value
+	Annotations map[string][]Annotation `json:"annotations,omitempty"`
 }
 
 type dbConfig struct {
@@ -26,7 +65,7 @@ type dbConfig struct {
 
 //DBWriter Writer interface for revdb
 type DBWriter interface {
-	WriteDB(ip string, domains []string) (int, error)
+	WriteDB(ip string, domains []string, ev Evidence) (int, error)
 }
 
 //DBReader Reader interface for revdb
@@ -34,6 +73,12 @@ type DBReader interface {
 	ReadDB(ip string) (DnsVal, error)
 }
 
+//BulkReader reads many ips in a single transaction, fn is called
+//in order of ips, with an empty value for unknown ips
+type BulkReader interface {
+	ReadDBMulti(ips []string, fn func(ip string, val DnsVal) error) error
+}
+
 //DBIface Interface for revdb operations
 type DBIface interface {
 	DBWriter
@@ -72,3 +117,67 @@ func NewDnsVal() *DnsVal {
 		Domains: make(map[string]DnsInfo),
 	}
 }
+
+//HasSource reports whether the pair was seen from src
+func (d DnsInfo) HasSource(src string) bool {
+	return inSet(d.Sources, src)
+}
+
+//HasSensor reports whether the pair was seen by sensor
+func (d DnsInfo) HasSensor(sensor string) bool {
+	return inSet(d.Sensors, sensor)
+}
+
+//Observe records evidence for the pair
+func (d *DnsInfo) Observe(ev Evidence) {
+	ts := ev.unix()
+	d.Sources = addToSet(d.Sources, ev.Source)
+	d.Sensors = addToSet(d.Sensors, ev.Sensor)
+	d.Count++
+	if d.FirstSeen == 0 || ts < d.FirstSeen {
+		d.FirstSeen = ts
+	}
+	if ts > d.LastSeen {
+		d.LastSeen = ts
+	}
+}
+
+//AddSensor records sensor as a contributor of the pair
+func (d *DnsInfo) AddSensor(sensor string) {
+	d.Sensors = addToSet(d.Sensors, sensor)
+}
+
+//Filter returns a copy of the value holding only the domains accepted by keep
+func (v DnsVal) Filter(keep func(name string, info DnsInfo) bool) DnsVal {
+	ret := DnsVal{
+		IpInfo:  v.IpInfo,
+		Domains: make(map[string]DnsInfo),
+	}
+	for name, info := range v.Domains {
+		if keep(name, info) {
+			ret.Domains[name] = info
+		}
+	}
+	return ret
+}
+
+//NormalizeName lowercases name and drops its trailing dot
+func NormalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func inSet(set []string, val string) bool {
+	for _, s := range set {
+		if s == val {
+			return true
+		}
+	}
+	return false
+}
+
+func addToSet(set []string, val string) []string {
+	if val == "" || inSet(set, val) {
+		return set
+	}
+	return append(set, val)
+}
@@ -1,13 +1,15 @@
 package revdb
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/gviz/revDNS/internal/wl"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 const (
@@ -15,6 +17,22 @@ const (
 	bucketName    = "dnsBucket"
 )
 
+var (
+	writeLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "revdns_db_write_seconds",
+		Help:    "Latency of revdb writes.",
+		Buckets: append([]float64{.0005, .001}, prometheus.DefBuckets...),
+	})
+	writeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "revdns_db_write_errors_total",
+		Help: "Failed revdb writes.",
+	})
+	newPairs = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "revdns_db_new_pairs_total",
+		Help: "Ip/domain pairs added to revdb.",
+	})
+)
+
 /*BoltDB Handler for boltdb backend*/
 type BoltDB struct {
 	name       string
@@ -23,6 +41,9 @@ type BoltDB struct {
 	db         *bolt.DB
 	bucket     *bolt.Bucket
 	wl         *wl.WhitelistDB
+	limits     Limits
+	encoding   Encoding
+	notify     func(m Mapping)
 	numEntries int
 	sslEntries int
 	dnsEntries int
@@ -40,6 +61,28 @@ func (b *BoltDB) String() string {
 	return "boltInterface for revdb"
 }
 
+//SetLimits bounds the domains kept per ip
+func (b *BoltDB) SetLimits(l Limits) {
+	b.limits = l
+}
+
+//SetEncoding selects the encoding of written values, values in
+//either encoding are always readable
+func (b *BoltDB) SetEncoding(enc Encoding) {
+	b.encoding = enc
+}
+
+//SetNotify registers fn to be called with every new ip/domain pair
+//after it is committed
+func (b *BoltDB) SetNotify(fn func(m Mapping)) {
+	b.notify = fn
+}
+
+//WhitelistSize returns the number of whitelisted domains
+func (b *BoltDB) WhitelistSize() int {
+	return b.wl.Len()
+}
+
 //IncSSLEntries tracks ssl entry additions
 func (b *BoltDB) IncSSLEntries(numEntries int) {
 	b.sslEntries += numEntries
@@ -54,16 +97,15 @@ func (b *BoltDB) IncDNSEntries(numEntries int) {
 func (b *BoltDB) ReadDB(ip string) (DnsVal, error) {
 	var domains DnsVal
 	err := b.db.View(func(tx *bolt.Tx) error {
-		val := NewDnsVal()
 		v := tx.Bucket([]byte(bucketName)).Get([]byte(ip))
 		if v != nil {
-			err := json.Unmarshal(v, &val)
+			val, err := DecodeValue(v)
 			if err != nil {
-				log.Println("Error decoding json val:", err)
+				log.Println("Error decoding val:", err)
 				return err
 			}
 			fmt.Println(val)
-			domains = *val
+			domains = val
 		}
 		return nil
 	})
@@ -71,44 +113,207 @@ func (b *BoltDB) ReadDB(ip string) (DnsVal, error) {
 	return domains, err
 }
 
-//WriteDB writes ip/domain information to boltdb
-func (b *BoltDB) WriteDB(ip string, domains []string) (int, error) {
+//ReadDBMulti reads ips in a single read transaction. fn is called after
+//the transaction closes so slow consumers don't hold it open.
+func (b *BoltDB) ReadDBMulti(ips []string, fn func(ip string, val DnsVal) error) error {
+	vals := make([]DnsVal, len(ips))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucketName))
+		for i, ip := range ips {
+			if v := bkt.Get([]byte(ip)); v != nil {
+				var err error
+				if vals[i], err = DecodeValue(v); err != nil {
+					log.Printf("Error decoding val for %s: %s", ip, err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, ip := range ips {
+		if err := fn(ip, vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//WriteDB writes ip/domain information to boltdb and returns the number
+//of new pairs
+func (b *BoltDB) WriteDB(ip string, domains []string, ev Evidence) (int, error) {
+	start := time.Now()
+	defer func() {
+		writeLatency.Observe(time.Since(start).Seconds())
+	}()
 	newEntry := true
+	var added []string
+	var val *DnsVal
 	err := b.db.Update(func(tx *bolt.Tx) error {
-		val := NewDnsVal()
+		val = NewDnsVal()
 		v := tx.Bucket([]byte(bucketName)).Get([]byte(ip))
 		if v != nil {
 			/*Update */
-			err := json.Unmarshal(v, &val)
+			old, err := DecodeValue(v)
 			if err != nil {
-				log.Println("Error decoding json val:", err)
+				log.Println("Error decoding val:", err)
 				return err
 			}
+			val = &old
 			newEntry = false
 		}
+		before := copyDnsVal(*val).Domains
 
 		for _, name := range domains {
+			//names are stored normalized so lookups, purges and
+			//annotations match them whatever the case sensors saw
+			if name = NormalizeName(name); name == "" {
+				continue
+			}
 			id := b.wl.Lookup(name)
 			//log.Printf("Doman: %s , WL: %d\n",
 			//name, id)
 
-			val.Domains[name] = DnsInfo{
-				WlId: id,
-			}
+			info := val.Domains[name]
+			info.WlId = id
+			b.limits.observe(&info, ev)
+			val.Domains[name] = info
 		}
+		b.limits.apply(val)
 
-		data, err := json.Marshal(&val)
+		data, err := EncodeValue(val, b.encoding)
 		if err != nil {
-			log.Println("Error encoding json")
+			log.Println("Error encoding val")
 			return err
 		}
 		err = tx.Bucket([]byte(bucketName)).Put([]byte(ip), data)
+		if err != nil {
+			return err
+		}
+		if added, err = reindex(tx, ip, before, val); err != nil {
+			return err
+		}
 		if newEntry {
 			b.numEntries++
 		}
-		return err
+		return nil
+	})
+	if err != nil {
+		writeErrors.Inc()
+		return 0, err
+	}
+	newPairs.Add(float64(len(added)))
+	if b.notify != nil {
+		for _, name := range added {
+			b.notify(Mapping{
+				IP:     ip,
+				Domain: name,
+				NewIP:  newEntry,
+				Source: ev.Source,
+				Sensor: ev.Sensor,
+				WlId:   val.Domains[name].WlId,
+				Time:   ev.unix(),
+			})
+		}
+	}
+	return len(added), nil
+}
+
+//ScanDB calls fn for every ip in a single read transaction
+func (b *BoltDB) ScanDB(fn func(ip string, val DnsVal) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(bucketName)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			val, err := DecodeValue(v)
+			if err != nil {
+				log.Printf("Error decoding val for %s: %s", k, err)
+				continue
+			}
+			if err := fn(string(k), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//scanBatch ips read per transaction by paged scans
+const scanBatch = 1000
+
+//ScanDBAfter calls fn for every ip sorting after after, all ips if it is
+//empty. Ips are read scanBatch at a time and fn is called between read
+//transactions, so slow consumers don't hold one open.
+func (b *BoltDB) ScanDBAfter(after string, fn func(ip string, val DnsVal) error) error {
+	from, skip := []byte(after), after != ""
+	for from != nil {
+		var recs []Record
+		err := b.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte(bucketName)).Cursor()
+			k, v := c.Seek(from)
+			if k != nil && skip && string(k) == string(from) {
+				k, v = c.Next()
+			}
+			var last []byte
+			for n := 0; k != nil && n < scanBatch; k, v = c.Next() {
+				n++
+				last = k
+				val, err := DecodeValue(v)
+				if err != nil {
+					log.Printf("Error decoding val for %s: %s", k, err)
+					continue
+				}
+				recs = append(recs, Record{IP: string(k), DnsVal: val})
+			}
+			from = nil
+			if k != nil {
+				from, skip = append([]byte{}, last...), true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if err := fn(rec.IP, rec.DnsVal); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//LoadDB merges complete records, one transaction per batch
+func (b *BoltDB) LoadDB(recs []Record) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucketName))
+		for _, rec := range recs {
+			val := NewDnsVal()
+			if v := bkt.Get([]byte(rec.IP)); v != nil {
+				old, err := DecodeValue(v)
+				if err != nil {
+					return err
+				}
+				val = &old
+			} else {
+				b.numEntries++
+			}
+			before := copyDnsVal(*val).Domains
+			b.limits.mergeVal(val, rec.DnsVal)
+			if _, err := reindex(tx, rec.IP, before, val); err != nil {
+				return err
+			}
+
+			data, err := EncodeValue(val, b.encoding)
+			if err != nil {
+				return err
+			}
+			if err := bkt.Put([]byte(rec.IP), data); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
-	return 0, err
 }
 
 //Close closes boltdb
@@ -118,16 +323,36 @@ func (b *BoltDB) Close() {
 
 // NewBoltDB Creates new boltdb
 func NewBoltDB(path string, file string, name string) *BoltDB {
+	b, err := CreateBoltDB(path, file, name, DefaultWhitelist())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return b
+}
+
+//DefaultWhitelist loads the Umbrella top sites whitelist
+func DefaultWhitelist() *wl.WhitelistDB {
+	w := &wl.WhitelistDB{
+		Name: "Umbrella",
+	}
+	w.Init("asdfasdf")
+	return w
+}
+
+//CreateBoltDB opens or creates a boltdb ranking domains by w, failing
+//instead of waiting if the file is locked by another process. Dbs may
+//share w.
+func CreateBoltDB(path string, file string, name string, w *wl.WhitelistDB) (*BoltDB, error) {
 	var bkt *bolt.Bucket
 	dbPath := path + "/" + file + ".db"
 	log.Printf("Opening %s\n", dbPath)
 
-	db, err := bolt.Open(dbPath, 0600, nil)
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%s: %s", dbPath, err)
 	}
 
-	db.Update(func(tx *bolt.Tx) error {
+	err = db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte("config"))
 		if b == nil {
 			v := tx.Bucket([]byte("config")).Get([]byte("version"))
@@ -146,17 +371,56 @@ func NewBoltDB(path string, file string, name string) *BoltDB {
 		bkt = b
 		return nil
 	})
-	w := wl.WhitelistDB{
-		Name: "Umbrella",
+	if err == nil {
+		err = buildDomainIndex(db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
-	w.Init("asdfasdf")
 	return &BoltDB{
 		name:   name,
 		path:   path,
 		db:     db,
 		bucket: bkt,
-		wl:     &w,
+		wl:     w,
+	}, nil
+}
+
+//OpenBoltDB opens a db file for tools, without loading the whitelist
+func OpenBoltDB(dbPath string, readOnly bool) (*BoltDB, error) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{
+		ReadOnly: readOnly,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", dbPath, err)
 	}
+	if readOnly {
+		err = db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket([]byte(bucketName)) == nil {
+				return fmt.Errorf("%s: not a revdb file", dbPath)
+			}
+			return nil
+		})
+	} else {
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+			return err
+		})
+		if err == nil {
+			err = buildDomainIndex(db)
+		}
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDB{
+		name: dbPath,
+		path: dbPath,
+		db:   db,
+	}, nil
 }
 
 //NewDefaultBoltDB returns boltdb with default values
@@ -1,12 +1,20 @@
 package revdb
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
 )
 
 func TestBoltOpen(t *testing.T) {
-	blt := NewboltDB("./", "test1", "tstDB")
+	blt := NewBoltDB("./", "test1", "tstDB")
 	defer blt.db.Close()
 	if blt == nil {
 		t.Errorf("BoltOpen:")
@@ -14,14 +22,14 @@ func TestBoltOpen(t *testing.T) {
 }
 
 func TestBoltReadWrite(t *testing.T) {
-	blt := NewboltDB("./", "test1", "tstDB")
+	blt := NewBoltDB("./", "test1", "tstDB")
 	defer blt.db.Close()
 	if blt == nil {
 		t.Errorf("BoltOpen:")
 	}
 
 	if data, err := blt.ReadDB("123.13.1.4"); err == nil {
-		if len(data) != 0 {
+		if len(data.Domains) != 0 {
 			t.Error("Negative test case failed")
 		}
 	} else {
@@ -29,14 +37,14 @@ func TestBoltReadWrite(t *testing.T) {
 	}
 
 	if _, err := blt.WriteDB("127.21.21.1",
-		[]string{"abc.com", "xyz.com", "rwe.cas"}); err != nil {
+		[]string{"abc.com", "xyz.com", "rwe.cas"}, Evidence{}); err != nil {
 		fmt.Println(err)
 		t.Error("Write Failed")
 	}
 
 	if domains, err := blt.ReadDB("127.21.21.1"); err == nil {
-		if len(domains) != 3 {
-			t.Errorf("Invalid read: %s", domains)
+		if len(domains.Domains) != 3 {
+			t.Errorf("Invalid read: %v", domains)
 			return
 		}
 	} else {
@@ -44,17 +52,371 @@ func TestBoltReadWrite(t *testing.T) {
 	}
 
 	if _, err := blt.WriteDB("127.21.21.1",
-		[]string{"123.com", "abc.com", "xyz.com", "rwe.cas"}); err != nil {
+		[]string{"123.com", "abc.com", "xyz.com", "rwe.cas"}, Evidence{}); err != nil {
 		fmt.Println(err)
 		t.Error("Write Failed")
 	}
 
 	if domains, err := blt.ReadDB("127.21.21.1"); err == nil {
-		if len(domains) != 4 {
-			t.Errorf("Invalid read: %s", domains)
+		if len(domains.Domains) != 4 {
+			t.Errorf("Invalid read: %v", domains)
 			return
 		}
 	} else {
 		t.Error("Positive read case failed")
 	}
 }
+
+func TestBoltProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blt := NewBoltDB(dir, "test2", "tstDB")
+	defer blt.Close()
+
+	ip := "10.1.1.1"
+	blt.WriteDB(ip, []string{"abc.com"}, Evidence{Source: SourceDNS, Sensor: "s1"})
+	blt.WriteDB(ip, []string{"abc.com", "spoof.com"}, Evidence{Source: SourceSSL, Sensor: "s2"})
+
+	val, err := blt.ReadDB(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abc := val.Domains["abc.com"]
+	if !abc.HasSource(SourceDNS) || !abc.HasSource(SourceSSL) ||
+		!abc.HasSensor("s1") || !abc.HasSensor("s2") {
+		t.Errorf("Invalid provenance: %v", abc)
+	}
+	if val.Domains["spoof.com"].HasSource(SourceDNS) {
+		t.Errorf("Invalid provenance: %v", val.Domains["spoof.com"])
+	}
+
+	dns := val.Filter(func(name string, info DnsInfo) bool {
+		return info.HasSource(SourceDNS)
+	})
+	if len(dns.Domains) != 1 {
+		t.Errorf("Invalid filter: %v", dns)
+	}
+}
+
+func TestBoltLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blt := NewBoltDB(dir, "test3", "tstDB")
+	defer blt.Close()
+	blt.SetLimits(Limits{MaxDomains: 2, Eviction: EvictCount})
+
+	ip := "10.1.1.2"
+	blt.WriteDB(ip, []string{"a.com", "b.com"}, Evidence{})
+	blt.WriteDB(ip, []string{"a.com", "b.com"}, Evidence{})
+	blt.WriteDB(ip, []string{"c.com"}, Evidence{})
+
+	val, err := blt.ReadDB(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(val.Domains) != 2 || !val.Shared {
+		t.Errorf("Invalid cap: %v", val)
+	}
+	if _, ok := val.Domains["c.com"]; ok {
+		t.Errorf("Invalid eviction: %v", val)
+	}
+	if top := val.Top(1); len(top.Domains) != 1 {
+		t.Errorf("Invalid top: %v", top)
+	}
+}
+
+func TestBoltExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := NewBoltDB(dir, "src", "tstDB")
+	defer src.Close()
+	src.WriteDB("10.1.1.1", []string{"a.example.com", "b.other.com"}, Evidence{Source: SourceDNS})
+	src.WriteDB("192.168.1.1", []string{"c.example.com"}, Evidence{Source: SourceSSL})
+
+	_, nets, _ := net.ParseCIDR("10.0.0.0/8")
+	var buf bytes.Buffer
+	n, err := Export(src, &buf, ExportFilter{Nets: []*net.IPNet{nets}, Suffix: "example.com"})
+	if err != nil || n != 1 {
+		t.Fatalf("Invalid export: %d %v", n, err)
+	}
+	dump := buf.String()
+
+	dst, err := OpenBoltDB(dir+"/dst.db", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	dst.SetEncoding(Encoding{Binary: true})
+	if n, err := Import(&buf, dst); err != nil || n != 1 {
+		t.Fatalf("Invalid import: %d %v", n, err)
+	}
+	val, _ := dst.ReadDB("10.1.1.1")
+	info, ok := val.Domains["a.example.com"]
+	if len(val.Domains) != 1 || !ok || !info.HasSource(SourceDNS) || info.Count != 1 {
+		t.Errorf("Invalid round trip: %v", val)
+	}
+
+	//backends without LoadDB get the records replayed
+	mem := newMemDB()
+	if n, err := Import(strings.NewReader(dump), mem); err != nil || n != 1 {
+		t.Fatalf("Invalid replay: %d %v", n, err)
+	}
+	info = mem.vals["10.1.1.1"].Domains["a.example.com"]
+	if !info.HasSource(SourceDNS) || info.FirstSeen == 0 {
+		t.Errorf("Invalid replay: %v", mem.vals)
+	}
+}
+
+func TestBoltLoadMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst, err := OpenBoltDB(dir+"/merged.db", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	rec := func(sensor string, count int, first, last int64) Record {
+		val := NewDnsVal()
+		val.Domains["abc.com"] = DnsInfo{
+			Sensors:   []string{sensor},
+			Count:     count,
+			FirstSeen: first,
+			LastSeen:  last,
+		}
+		return Record{IP: "10.1.1.1", DnsVal: *val}
+	}
+	dst.LoadDB([]Record{rec("s1", 2, 100, 200)})
+	dst.LoadDB([]Record{rec("s2", 3, 50, 150)})
+
+	val, _ := dst.ReadDB("10.1.1.1")
+	info := val.Domains["abc.com"]
+	if info.Count != 5 || info.FirstSeen != 50 || info.LastSeen != 200 {
+		t.Errorf("Invalid merge: %+v", info)
+	}
+	if !info.HasSensor("s1") || !info.HasSensor("s2") || !info.SeenAt(175) {
+		t.Errorf("Invalid merge: %+v", info)
+	}
+}
+
+func TestBoltScanAfter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBoltDB(dir+"/scan.db", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	var recs []Record
+	for i := 0; i < scanBatch+10; i++ {
+		val := NewDnsVal()
+		val.Domains["abc.com"] = DnsInfo{Count: 1}
+		recs = append(recs, Record{IP: fmt.Sprintf("10.%d.%d.1", i/256, i%256), DnsVal: *val})
+	}
+	if err := b.LoadDB(recs); err != nil {
+		t.Fatal(err)
+	}
+	scan := func(after string) []string {
+		var ips []string
+		if err := b.ScanDBAfter(after, func(ip string, val DnsVal) error {
+			ips = append(ips, ip)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return ips
+	}
+	all := scan("")
+	if len(all) != len(recs) {
+		t.Fatalf("Invalid scan: %d of %d ips", len(all), len(recs))
+	}
+	if rest := scan(all[4]); len(rest) != len(all)-5 || rest[0] != all[5] {
+		t.Errorf("Invalid scan after %s: %d ips", all[4], len(rest))
+	}
+}
+
+func TestBoltAnnotations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blt, err := OpenBoltDB(dir+"/notes.db", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blt.Close()
+
+	blt.Annotate(AnnotateIP, "10.1.1.1", Annotation{Tags: []string{"c2"}, Author: "alice"})
+	blt.Annotate(AnnotateDomain, "evil.com", Annotation{Tags: []string{"c2", "phish"}, Author: "bob"})
+	blt.Annotate(AnnotateDomain, "evil.com", Annotation{Note: "seen in case 12", Author: "bob"})
+	blt.WriteDB("10.1.1.1", []string{"evil.com"}, Evidence{})
+
+	notes, err := blt.Annotations(AnnotateDomain, []string{"evil.com", "good.com"})
+	if err != nil || len(notes) != 1 || len(notes["evil.com"]) != 2 {
+		t.Errorf("Invalid annotations: %v %v", notes, err)
+	}
+	refs, err := blt.SearchTag("c2")
+	if err != nil || len(refs) != 2 {
+		t.Errorf("Invalid tag search: %v %v", refs, err)
+	}
+	if refs, _ := blt.SearchTag("phish"); len(refs) != 1 || refs[0].Key != "evil.com" {
+		t.Errorf("Invalid tag search: %v", refs)
+	}
+}
+
+func TestBoltDomainIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blt := NewBoltDB(dir, "test7", "tstDB")
+	defer blt.Close()
+	blt.SetLimits(Limits{MaxDomains: 1, Eviction: EvictLRS})
+	var seen []Mapping
+	blt.SetNotify(func(m Mapping) { seen = append(seen, m) })
+
+	t0 := time.Unix(1500000000, 0)
+	n, _ := blt.WriteDB("10.1.1.1", []string{"a.com"}, Evidence{Source: SourceDNS, Ts: t0})
+	blt.WriteDB("10.1.1.2", []string{"a.com"}, Evidence{Source: SourceSSL, Ts: t0})
+	if n != 1 || len(seen) != 2 || !seen[0].NewIP || seen[0].Source != SourceDNS {
+		t.Errorf("Invalid notify: %d %v", n, seen)
+	}
+	if n, _ := blt.WriteDB("10.1.1.1", []string{"a.com"}, Evidence{Ts: t0}); n != 0 || len(seen) != 2 {
+		t.Errorf("Invalid repeat notify: %d %v", n, seen)
+	}
+
+	ips, err := blt.ReadDomain("a.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || ips["10.1.1.1"].Count != 2 {
+		t.Errorf("Invalid domain lookup: %v", ips)
+	}
+
+	//b.com evicts a.com from 10.1.1.2
+	blt.WriteDB("10.1.1.2", []string{"b.com"}, Evidence{Ts: t0.Add(time.Hour)})
+	if ips, _ = blt.ReadDomain("a.com"); len(ips) != 1 {
+		t.Errorf("Evicted pair still indexed: %v", ips)
+	}
+	if ips, _ = blt.ReadDomain("b.com"); len(ips) != 1 {
+		t.Errorf("Invalid domain lookup: %v", ips)
+	}
+
+	//names are indexed normalized
+	blt.WriteDB("10.1.1.3", []string{"Mixed.COM."}, Evidence{Ts: t0})
+	if ips, _ = blt.ReadDomain("mixed.com"); len(ips) != 1 {
+		t.Errorf("Mixed case name not indexed: %v", ips)
+	}
+	if val, _ := blt.ReadDB("10.1.1.3"); len(val.Domains) != 1 || val.Domains["mixed.com"].Count != 1 {
+		t.Errorf("Name not stored normalized: %v", val)
+	}
+
+	//dbs without an index get it built on open
+	blt.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(domainBucket))
+	})
+	blt.Close()
+	if blt, err = OpenBoltDB(dir+"/test7.db", false); err != nil {
+		t.Fatal(err)
+	}
+	defer blt.Close()
+	if ips, _ = blt.ReadDomain("b.com"); len(ips) != 1 {
+		t.Errorf("Index not rebuilt: %v", ips)
+	}
+}
+
+func TestBoltPurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blt := NewBoltDB(dir, "purge", "tstDB")
+	defer blt.Close()
+	blt.WriteDB("10.1.1.1", []string{"a.example.com", "good.com"}, Evidence{Source: SourceDNS})
+	blt.WriteDB("10.1.1.1", []string{"a.example.com", "spoof.com"}, Evidence{Source: SourceSSL})
+	blt.WriteDB("10.1.1.2", []string{"b.example.com"}, Evidence{Source: SourceSSL})
+	blt.Annotate(AnnotateIP, "10.1.1.2", Annotation{Tags: []string{"c2"}, Author: "a"})
+
+	if _, err := blt.Purge(Purge{}, false); err == nil {
+		t.Errorf("Purge without selector accepted")
+	}
+	res, err := blt.Purge(Purge{Source: SourceSSL}, true)
+	if err != nil || res.IPs != 2 || res.Pairs != 2 || res.Updated != 1 || res.Deleted != 1 {
+		t.Fatalf("Invalid dry run: %+v %v", res, err)
+	}
+	if val, _ := blt.ReadDB("10.1.1.2"); len(val.Domains) != 1 {
+		t.Errorf("Dry run purged: %v", val)
+	}
+	blt.Purge(Purge{Source: SourceSSL}, false)
+	val, _ := blt.ReadDB("10.1.1.1")
+	if _, ok := val.Domains["spoof.com"]; ok || len(val.Domains) != 2 {
+		t.Errorf("Invalid purge: %v", val)
+	}
+	if info := val.Domains["a.example.com"]; info.HasSource(SourceSSL) || !info.HasSource(SourceDNS) {
+		t.Errorf("Source not removed: %+v", info)
+	}
+	if val, _ := blt.ReadDB("10.1.1.2"); len(val.Domains) != 0 {
+		t.Errorf("Empty ip not deleted: %v", val)
+	}
+	if ips, _ := blt.ReadDomain("spoof.com"); len(ips) != 0 {
+		t.Errorf("Index not updated: %v", ips)
+	}
+	if notes, _ := blt.Annotations(AnnotateIP, []string{"10.1.1.2"}); len(notes["10.1.1.2"]) != 1 {
+		t.Errorf("Annotations purged: %v", notes)
+	}
+
+	res, _ = blt.Purge(Purge{Domain: "example.com", Suffix: true}, false)
+	if res.Pairs != 1 {
+		t.Errorf("Invalid suffix purge: %+v", res)
+	}
+	res, _ = blt.Purge(Purge{IP: "10.1.1.1", Domain: "good.com"}, false)
+	if res.Pairs != 1 || res.Deleted != 1 {
+		t.Errorf("Invalid pair purge: %+v", res)
+	}
+	//names are matched normalized, also in values loaded with their case
+	old := NewDnsVal()
+	old.Domains["Old.Example.COM."] = DnsInfo{Sources: []string{SourceDNS}}
+	blt.LoadDB([]Record{{IP: "10.1.1.3", DnsVal: *old}})
+	blt.WriteDB("10.1.1.4", []string{"OLD.example.com"}, Evidence{Source: SourceDNS})
+	res, _ = blt.Purge(Purge{Domain: "old.example.com"}, false)
+	if res.Pairs != 2 {
+		t.Errorf("Invalid mixed case purge: %+v", res)
+	}
+
+	//purges span several transactions
+	var recs []Record
+	for i := 0; i < 2*purgeBatch+10; i++ {
+		val := NewDnsVal()
+		val.Domains["replay.test"] = DnsInfo{Sources: []string{SourceHTTP}, FirstSeen: 100, LastSeen: 100}
+		recs = append(recs, Record{IP: fmt.Sprintf("10.2.%d.%d", i/256, i%256), DnsVal: *val})
+	}
+	if err := blt.LoadDB(recs); err != nil {
+		t.Fatal(err)
+	}
+	res, err = blt.Purge(Purge{Source: SourceHTTP}, false)
+	if err != nil || res.Deleted != len(recs) || len(res.Changed) != len(recs) {
+		t.Errorf("Invalid batched purge: %d deleted %v", res.Deleted, err)
+	}
+	if ips, _ := blt.ReadDomain("replay.test"); len(ips) != 0 {
+		t.Errorf("Batched purge left %d ips", len(ips))
+	}
+}
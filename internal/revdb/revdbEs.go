@@ -57,6 +57,8 @@ type EsDB struct {
 type dnsEsObj struct {
 	IP      string
 	Domains []string
+	Source  string `json:",omitempty"`
+	Sensor  string `json:",omitempty"`
 }
 
 /*ReadDB gets information from ES*/
@@ -73,9 +75,14 @@ func (dns *EsDB) ReadDB(ip string) (int, error) {
 }
 
 /*WriteDB writes to ES*/
-func (dns *EsDB) WriteDB(ip string, domains []string) (int, error) {
+func (dns *EsDB) WriteDB(ip string, domains []string, ev Evidence) (int, error) {
 	log.Printf("Index: %s\n", dns.index)
-	j, _ := json.Marshal(&dnsEsObj{IP: ip, Domains: domains})
+	j, _ := json.Marshal(&dnsEsObj{
+		IP:      ip,
+		Domains: domains,
+		Source:  ev.Source,
+		Sensor:  ev.Sensor,
+	})
 
 	fmt.Println((string(j)))
 	req := esapi.IndexRequest{
@@ -180,7 +187,8 @@ func ImportBroDNSEntries(writer DBIface) int {
 							revdb.db[ip.(string)] = dnsObj{name: make(map[string]struct{})}
 						}
 						revdb.db[ip.(string)].name[host] = struct{}{}
-						writer.WriteDB(ip.(string), []string{query})
+						writer.WriteDB(ip.(string), []string{query},
+							Evidence{Source: SourceDNS})
 						count++
 					}
 				} else {
@@ -241,7 +249,7 @@ func ImportBroSSLEntries(writer DBIface) int {
 				ts := getVal(broSSL.(map[string]interface{}), "ts")
 				logStr := fmt.Sprintf("%s %s %s ", ts, host, ip)
 				log.Print(logStr)
-				writer.WriteDB(ip, []string{host})
+				writer.WriteDB(ip, []string{host}, Evidence{Source: SourceSSL})
 				count++
 			}
 		}
@@ -0,0 +1,29 @@
+package revdb
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+//maxEpoch 9999-12-31T23:59:59Z, larger values are usually milliseconds
+const maxEpoch = 253402300799
+
+//ParseTime parses unix seconds or RFC3339 timestamps
+func ParseTime(ts string) (time.Time, error) {
+	if secs, err := strconv.ParseFloat(ts, 64); err == nil {
+		return EpochTime(secs)
+	}
+	return time.Parse(time.RFC3339, ts)
+}
+
+//EpochTime converts fractional unix seconds, splitting seconds and
+//fraction so the nanoseconds don't overflow
+func EpochTime(secs float64) (time.Time, error) {
+	if math.IsNaN(secs) || secs < 0 || secs > maxEpoch {
+		return time.Time{}, fmt.Errorf("epoch %v out of range", secs)
+	}
+	sec, frac := math.Modf(secs)
+	return time.Unix(int64(sec), int64(frac*1e9)), nil
+}
@@ -11,12 +11,17 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	ciscoUmbrella = "http://s3-us-west-1.amazonaws.com/umbrella-static/top-1m.csv.zip"
 )
 
+//client downloads the whitelist, a stalled download leaves it empty
+//instead of blocking its users
+var client = &http.Client{Timeout: 5 * time.Minute}
+
 //WhitelistDB stores domains imported from whitelist
 type WhitelistDB struct {
 	Name  string
@@ -26,6 +31,9 @@ type WhitelistDB struct {
 
 //Lookup returns the rank in WL if entry is present
 func (w *WhitelistDB) Lookup(domain string) int {
+	if w == nil {
+		return 0
+	}
 	rank, ok := w.db[domain]
 	if ok {
 		return rank
@@ -33,6 +41,14 @@ func (w *WhitelistDB) Lookup(domain string) int {
 	return 0
 }
 
+//Len returns the number of whitelisted domains
+func (w *WhitelistDB) Len() int {
+	if w == nil {
+		return 0
+	}
+	return len(w.db)
+}
+
 //List returns whitelist entries
 func (w *WhitelistDB) List() {
 	for key := range w.db {
@@ -49,14 +65,22 @@ func (w *WhitelistDB) Init(file string) {
 		//Default Whitelist
 		w.WlURL = ciscoUmbrella
 		log.Println("Downloading wl ...")
-		resp, err := http.Get(w.WlURL)
+		resp, err := client.Get(w.WlURL)
 		if err != nil {
 			log.Println("Error downloading umbrella list")
 			return
 		}
 		defer resp.Body.Close()
 		body, err := ioutil.ReadAll(resp.Body)
-		zp, err := zip.NewReader(bytes.NewReader(body), resp.ContentLength)
+		if err != nil {
+			log.Println("Error downloading umbrella list:", err)
+			return
+		}
+		zp, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			log.Println("Error reading umbrella list:", err)
+			return
+		}
 		for _, f := range zp.File {
 			z, err := f.Open()
 			if err != nil {
@@ -0,0 +1,307 @@
+package zeek
+
+//Enrich Zeek conn logs with the names revdb knows for their endpoints
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+const (
+	//data lines looked up at once
+	batchSize = 1000
+	maxLine   = 1 << 20
+
+	respField = "id.resp_h"
+	origField = "id.orig_h"
+)
+
+//Lookup returns the known ips among ips
+type Lookup func(ips []string) (map[string]revdb.DnsVal, error)
+
+//Options of an enrichment
+type Options struct {
+	//also add orig_domains and orig_wl_rank for id.orig_h
+	Orig bool
+	//domains added per ip, most observed first, 0 for all
+	Top int
+}
+
+//enricher state of one log, TSV header fields are tracked as the log
+//declares them
+type enricher struct {
+	w      *bufio.Writer
+	lookup Lookup
+	opts   Options
+
+	sep, setSep, empty, unset string
+	//indexes of the looked up fields, -1 when not enriched
+	resp, orig int
+
+	batch    []string
+	enriched int
+}
+
+//Enrich copies a conn log in Zeek TSV or JSON format from r to w,
+//adding resp_domains and resp_wl_rank, and orig_domains and orig_wl_rank
+//with opts.Orig. TSV headers are rewritten to declare the added fields.
+//It returns the number of records with a known ip. On errors the lines
+//enriched so far are written out whole, so callers can mark the failure
+//after them.
+func Enrich(r io.Reader, w io.Writer, lookup Lookup, opts Options) (int, error) {
+	e := &enricher{
+		w:      bufio.NewWriter(w),
+		lookup: lookup,
+		opts:   opts,
+		sep:    "\t",
+		setSep: ",",
+		empty:  "(empty)",
+		unset:  "-",
+		resp:   -1,
+		orig:   -1,
+	}
+	err := e.run(r)
+	if ferr := e.w.Flush(); err == nil {
+		err = ferr
+	}
+	return e.enriched, err
+}
+
+func (e *enricher) run(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxLine)
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if strings.HasPrefix(line, "#") {
+			if err := e.flush(); err != nil {
+				return err
+			}
+			e.header(line)
+			continue
+		}
+		e.batch = append(e.batch, line)
+		if len(e.batch) == batchSize {
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return e.flush()
+}
+
+//unescape decodes the \xHH escapes of header values
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if v, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+//escape encodes the separators in a value as Zeek does
+func (e *enricher) escape(s string) string {
+	for _, sep := range []string{e.sep, e.setSep} {
+		if strings.Contains(s, sep) {
+			var esc strings.Builder
+			for i := 0; i < len(sep); i++ {
+				fmt.Fprintf(&esc, "\\x%02x", sep[i])
+			}
+			s = strings.Replace(s, sep, esc.String(), -1)
+		}
+	}
+	return s
+}
+
+//header tracks the format a TSV header declares and adds the new
+//fields to #fields and #types
+func (e *enricher) header(line string) {
+	if strings.HasPrefix(line, "#separator ") {
+		e.sep = unescape(strings.TrimPrefix(line, "#separator "))
+		e.w.WriteString(line + "\n")
+		return
+	}
+	parts := strings.Split(line, e.sep)
+	switch parts[0] {
+	case "#set_separator":
+		if len(parts) > 1 {
+			e.setSep = unescape(parts[1])
+		}
+	case "#empty_field":
+		if len(parts) > 1 {
+			e.empty = unescape(parts[1])
+		}
+	case "#unset_field":
+		if len(parts) > 1 {
+			e.unset = unescape(parts[1])
+		}
+	case "#fields":
+		e.resp, e.orig = -1, -1
+		for i, f := range parts[1:] {
+			switch f {
+			case respField:
+				e.resp = i
+			case origField:
+				if e.opts.Orig {
+					e.orig = i
+				}
+			}
+		}
+		if e.resp >= 0 {
+			line += e.sep + "resp_domains" + e.sep + "resp_wl_rank"
+		}
+		if e.orig >= 0 {
+			line += e.sep + "orig_domains" + e.sep + "orig_wl_rank"
+		}
+	case "#types":
+		if e.resp >= 0 {
+			line += e.sep + "set[string]" + e.sep + "count"
+		}
+		if e.orig >= 0 {
+			line += e.sep + "set[string]" + e.sep + "count"
+		}
+	}
+	e.w.WriteString(line + "\n")
+}
+
+//jsonRecord the looked up fields of a JSON log line
+type jsonRecord struct {
+	Resp string `json:"id.resp_h"`
+	Orig string `json:"id.orig_h"`
+}
+
+//ips returns the resp and orig ips of a data line
+func (e *enricher) ips(line string) (string, string) {
+	if strings.HasPrefix(line, "{") {
+		var rec jsonRecord
+		if json.Unmarshal([]byte(line), &rec) != nil {
+			return "", ""
+		}
+		if !e.opts.Orig {
+			rec.Orig = ""
+		}
+		return rec.Resp, rec.Orig
+	}
+	fields := strings.Split(line, e.sep)
+	var resp, orig string
+	if e.resp >= 0 && e.resp < len(fields) && fields[e.resp] != e.unset {
+		resp = fields[e.resp]
+	}
+	if e.orig >= 0 && e.orig < len(fields) && fields[e.orig] != e.unset {
+		orig = fields[e.orig]
+	}
+	return resp, orig
+}
+
+//names returns the domains added for val, most observed first, and the
+//best whitelist rank among them, 0 when none is ranked
+func (e *enricher) names(val revdb.DnsVal) ([]string, int) {
+	names := val.Ranked()
+	if e.opts.Top > 0 && len(names) > e.opts.Top {
+		names = names[:e.opts.Top]
+	}
+	rank := 0
+	for _, info := range val.Domains {
+		if id := info.WlId; id > 0 && (rank == 0 || id < rank) {
+			rank = id
+		}
+	}
+	return names, rank
+}
+
+//tsvColumns the domains and rank columns of ip
+func (e *enricher) tsvColumns(ip string, vals map[string]revdb.DnsVal) string {
+	val, ok := vals[ip]
+	if !ok {
+		return e.sep + e.unset + e.sep + e.unset
+	}
+	names, rank := e.names(val)
+	set := e.empty
+	if len(names) > 0 {
+		for i, name := range names {
+			names[i] = e.escape(name)
+		}
+		set = strings.Join(names, e.setSep)
+	}
+	return e.sep + set + e.sep + strconv.Itoa(rank)
+}
+
+//jsonFields the domains and rank fields of ip, none for unknown ips
+//as Zeek leaves out unset fields
+func (e *enricher) jsonFields(prefix string, ip string, vals map[string]revdb.DnsVal) string {
+	val, ok := vals[ip]
+	if !ok {
+		return ""
+	}
+	names, rank := e.names(val)
+	data, _ := json.Marshal(names)
+	return fmt.Sprintf(`,"%s_domains":%s,"%s_wl_rank":%d`, prefix, data, prefix, rank)
+}
+
+//flush looks up the ips of the batched lines and writes them enriched
+func (e *enricher) flush() error {
+	if len(e.batch) == 0 {
+		return nil
+	}
+	type lineIPs struct{ resp, orig string }
+	lines := make([]lineIPs, len(e.batch))
+	seen := make(map[string]bool)
+	var ips []string
+	for i, line := range e.batch {
+		resp, orig := e.ips(line)
+		lines[i] = lineIPs{resp, orig}
+		for _, ip := range []string{resp, orig} {
+			if ip != "" && !seen[ip] {
+				seen[ip] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+	vals, err := e.lookup(ips)
+	if err != nil {
+		return err
+	}
+
+	for i, line := range e.batch {
+		ips := lines[i]
+		if _, ok := vals[ips.resp]; ok {
+			e.enriched++
+		} else if _, ok := vals[ips.orig]; ok {
+			e.enriched++
+		}
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "{"):
+			trimmed := strings.TrimRight(line, " \t")
+			if n := len(trimmed); trimmed[n-1] == '}' {
+				line = trimmed[:n-1] + e.jsonFields("resp", ips.resp, vals) +
+					e.jsonFields("orig", ips.orig, vals) + "}"
+			}
+		default:
+			if e.resp >= 0 {
+				line += e.tsvColumns(ips.resp, vals)
+			}
+			if e.orig >= 0 {
+				line += e.tsvColumns(ips.orig, vals)
+			}
+		}
+		e.w.WriteString(line + "\n")
+	}
+	e.batch = e.batch[:0]
+	return nil
+}
@@ -0,0 +1,94 @@
+package zeek
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+func testLookup(ips []string) (map[string]revdb.DnsVal, error) {
+	known := map[string]revdb.DnsVal{
+		"10.1.1.1": {Domains: map[string]revdb.DnsInfo{
+			"a.example.com": {WlId: 0, Count: 5},
+			"b.example.com": {WlId: 120, Count: 1},
+			"c.example.com": {WlId: 80, Count: 2},
+		}},
+		"10.1.1.2": {Domains: map[string]revdb.DnsInfo{}},
+	}
+	vals := make(map[string]revdb.DnsVal)
+	for _, ip := range ips {
+		if val, ok := known[ip]; ok {
+			vals[ip] = val
+		}
+	}
+	return vals, nil
+}
+
+func TestEnrichTSV(t *testing.T) {
+	in := strings.Join([]string{
+		`#separator \x09`,
+		"#set_separator\t,",
+		"#empty_field\t(empty)",
+		"#unset_field\t-",
+		"#path\tconn",
+		"#fields\tts\tuid\tid.orig_h\tid.resp_h",
+		"#types\ttime\tstring\taddr\taddr",
+		"1.0\tC1\t192.168.1.1\t10.1.1.1",
+		"2.0\tC2\t192.168.1.1\t10.1.1.2",
+		"3.0\tC3\t192.168.1.1\t10.9.9.9",
+		"#close\t2019-04-01-10-00-00",
+	}, "\n") + "\n"
+	var out bytes.Buffer
+	n, err := Enrich(strings.NewReader(in), &out, testLookup, Options{Top: 2})
+	if err != nil || n != 2 {
+		t.Fatalf("Invalid enrichment: %d %v", n, err)
+	}
+	want := strings.Join([]string{
+		`#separator \x09`,
+		"#set_separator\t,",
+		"#empty_field\t(empty)",
+		"#unset_field\t-",
+		"#path\tconn",
+		"#fields\tts\tuid\tid.orig_h\tid.resp_h\tresp_domains\tresp_wl_rank",
+		"#types\ttime\tstring\taddr\taddr\tset[string]\tcount",
+		"1.0\tC1\t192.168.1.1\t10.1.1.1\ta.example.com,c.example.com\t80",
+		"2.0\tC2\t192.168.1.1\t10.1.1.2\t(empty)\t0",
+		"3.0\tC3\t192.168.1.1\t10.9.9.9\t-\t-",
+		"#close\t2019-04-01-10-00-00",
+	}, "\n") + "\n"
+	if out.String() != want {
+		t.Errorf("Invalid log:\n%s", out.String())
+	}
+}
+
+func TestEnrichJSON(t *testing.T) {
+	in := `{"ts":1.0,"id.orig_h":"10.1.1.1","id.resp_h":"10.9.9.9"}` + "\n" +
+		`{"ts":2.0,"id.orig_h":"192.168.1.1","id.resp_h":"10.1.1.1"}` + "\n"
+	var out bytes.Buffer
+	if _, err := Enrich(strings.NewReader(in), &out, testLookup, Options{Orig: true, Top: 1}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"ts":1.0,"id.orig_h":"10.1.1.1","id.resp_h":"10.9.9.9","orig_domains":["a.example.com"],"orig_wl_rank":80}` + "\n" +
+		`{"ts":2.0,"id.orig_h":"192.168.1.1","id.resp_h":"10.1.1.1","resp_domains":["a.example.com"],"resp_wl_rank":80}` + "\n"
+	if out.String() != want {
+		t.Errorf("Invalid log:\n%s", out.String())
+	}
+}
+
+func TestEnrichLookupError(t *testing.T) {
+	in := "#fields\tts\tid.orig_h\tid.resp_h\n1.0\t192.168.1.1\t10.1.1.1\n"
+	failing := func(ips []string) (map[string]revdb.DnsVal, error) {
+		return nil, errors.New("db closed")
+	}
+	var out bytes.Buffer
+	if _, err := Enrich(strings.NewReader(in), &out, failing, Options{}); err == nil {
+		t.Fatal("Lookup error not returned")
+	}
+	//lines before the failure are written out whole
+	if want := "#fields\tts\tid.orig_h\tid.resp_h\tresp_domains\tresp_wl_rank\n"; out.String() != want {
+		t.Errorf("Invalid partial log:\n%q", out.String())
+	}
+}
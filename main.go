@@ -17,13 +17,13 @@ func main() {
 	}
 
 	r := NewRevDns(conf)
-	r.Start()
-
 	s := &stream{
 		brokers: []string{conf.Kafka.Host},
 		topic:   conf.Kafka.Topic,
 		writer:  r.writer,
 	}
 	s.Init(conf)
+	r.input = s
+	r.Start()
 	s.Run()
 }
@@ -1,10 +1,37 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/gviz/revDNS/api/revdnspb"
+	"github.com/gviz/revDNS/internal/auth"
+	"github.com/gviz/revDNS/internal/revconfig"
+	"github.com/gviz/revDNS/internal/revdb"
 	"github.com/gviz/revDNS/internal/wl"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestAlexaHandler(T *testing.T) {
@@ -16,3 +43,700 @@ func TestAlexaHandler(T *testing.T) {
 	//w.List()
 
 }
+
+func TestParseBulk(T *testing.T) {
+	for _, body := range []string{
+		`["10.0.0.1", "10.0.0.2", "::1"]`,
+		"\"10.0.0.1\"\n{\"ip\": \"10.0.0.2\"}\n\n::1\n",
+	} {
+		ips, err := parseBulk(strings.NewReader(body), 10)
+		if err != nil || len(ips) != 3 || ips[2] != "::1" {
+			T.Errorf("Invalid bulk parse %q: %v %v", body, ips, err)
+		}
+	}
+	if _, err := parseBulk(strings.NewReader(`["10.0.0.1", "10.0.0.2"]`), 1); err != errBulkTooLarge {
+		T.Errorf("Bulk limit not enforced: %v", err)
+	}
+	if _, err := parseBulk(strings.NewReader(`["10.0.0.300"]`), 10); err == nil {
+		T.Error("Invalid ip accepted")
+	}
+	ips, err := parseBulk(strings.NewReader(`["2001:DB8::1", "::ffff:10.1.1.1"]`), 10)
+	if err != nil || ips[0] != "2001:db8::1" || ips[1] != "10.1.1.1" {
+		T.Errorf("Ips not canonical: %v %v", ips, err)
+	}
+}
+
+func TestLkupStatus(T *testing.T) {
+	c := make(chan lkupReq)
+	l := withTimeout(&lkup{c: c}, 50*time.Millisecond)
+	get := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/revdns/api/v1/ip/"+ip, nil)
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, mux.SetURLVars(req, map[string]string{"ip": ip}))
+		return w
+	}
+
+	if w := get("10.0.0.300"); w.Code != http.StatusBadRequest {
+		T.Errorf("Invalid ip: %d", w.Code)
+	}
+
+	//nobody serves the request
+	w := get("10.0.0.1")
+	if w.Code != http.StatusGatewayTimeout {
+		T.Errorf("Request not timed out: %d", w.Code)
+	}
+	var body map[string]apiError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["error"].Code != w.Code {
+		T.Errorf("Invalid error envelope: %v %v", body, err)
+	}
+
+	go func() {
+		req := <-c
+		req.rsp <- lkupRsp{err: errDBUnavailable}
+	}()
+	if w := get("10.0.0.1"); w.Code != http.StatusServiceUnavailable {
+		T.Errorf("Invalid unavailable status: %d", w.Code)
+	}
+}
+
+func TestGrpcLookup(T *testing.T) {
+	r := NewRevDns(&revconfig.RevConfig{})
+	g := &grpcServer{r: r}
+	go func() {
+		for req := range r.httpReq {
+			if req.ip != "10.0.0.1" {
+				req.rsp <- lkupRsp{err: errNotFound}
+				continue
+			}
+			val := revdb.NewDnsVal()
+			val.Domains["a.com"] = revdb.DnsInfo{Count: 1}
+			val.Domains["b.com"] = revdb.DnsInfo{Count: 2}
+			req.rsp <- lkupRsp{val: *val}
+		}
+	}()
+	defer close(r.httpReq)
+
+	ctx := context.Background()
+	reply, err := g.LookupIP(ctx, &revdnspb.IPRequest{Ip: "10.0.0.1"})
+	if err != nil || !reply.Found || len(reply.Domains) != 2 || reply.Domains[0].Name != "b.com" {
+		T.Errorf("Invalid reply: %v %v", reply, err)
+	}
+	if reply, err := g.LookupIP(ctx, &revdnspb.IPRequest{Ip: "10.0.0.2"}); err != nil || reply.Found {
+		T.Errorf("Invalid unknown ip reply: %v %v", reply, err)
+	}
+	if _, err := g.LookupIP(ctx, &revdnspb.IPRequest{Ip: "x"}); status.Code(err) != codes.InvalidArgument {
+		T.Errorf("Invalid ip status: %v", err)
+	}
+	if _, err := g.LookupIP(ctx, &revdnspb.IPRequest{Ip: "10.0.0.1", Tenant: "acme"}); status.Code(err) != codes.PermissionDenied {
+		T.Errorf("Invalid tenant status: %v", err)
+	}
+}
+
+func TestFeedDropsSlow(T *testing.T) {
+	f := newFeed()
+	fast := f.subscribe("default", nil)
+	slow := f.subscribe("default", func(m revdb.Mapping) bool { return m.NewIP })
+	for i := 0; i < feedBuffer+1; i++ {
+		f.publish("default", revdb.Mapping{NewIP: true})
+		if i < feedBuffer {
+			<-fast.c
+		}
+	}
+	select {
+	case <-slow.dropped:
+	default:
+		T.Errorf("Slow subscriber not dropped")
+	}
+	select {
+	case <-fast.dropped:
+		T.Errorf("Fast subscriber dropped")
+	default:
+	}
+	f.publish("other", revdb.Mapping{})
+	if len(fast.c) != 1 {
+		T.Errorf("Invalid tenant fan out: %d", len(fast.c))
+	}
+}
+
+func TestFeedFilter(T *testing.T) {
+	q, _ := url.ParseQuery("cidr=10.0.0.0/8&suffix=example.com&source=dns&unranked_only=true")
+	f, err := newFeedFilter(q)
+	if err != nil {
+		T.Fatal(err)
+	}
+	m := revdb.Mapping{IP: "10.1.2.3", Domain: "www.example.com", Source: revdb.SourceDNS}
+	if !f.match(m) {
+		T.Errorf("Mapping not matched: %+v", m)
+	}
+	for _, bad := range []revdb.Mapping{
+		{IP: "192.168.1.1", Domain: "www.example.com", Source: revdb.SourceDNS},
+		{IP: "10.1.2.3", Domain: "example.org", Source: revdb.SourceDNS},
+		{IP: "10.1.2.3", Domain: "www.example.com", Source: revdb.SourceSSL},
+		{IP: "10.1.2.3", Domain: "www.example.com", Source: revdb.SourceDNS, WlId: 10},
+	} {
+		if f.match(bad) {
+			T.Errorf("Mapping matched: %+v", bad)
+		}
+	}
+	for _, query := range []string{"cidr=10.0.0.0", "source=ftp", "max_rank=0", "max_rank=10&unranked_only=true"} {
+		q, _ := url.ParseQuery(query)
+		if _, err := newFeedFilter(q); err == nil {
+			T.Errorf("Invalid filter %q accepted", query)
+		}
+	}
+}
+
+func TestNewPurge(T *testing.T) {
+	req := httptest.NewRequest("DELETE", "/revdns/api/v1/domain/Example.COM.?suffix=true&dry_run=true", nil)
+	p, dryRun, err := newPurge(mux.SetURLVars(req, map[string]string{"domain": "Example.COM."}))
+	if err != nil || !dryRun || p.Domain != "example.com" || !p.Suffix {
+		T.Errorf("Invalid purge: %+v %t %v", p, dryRun, err)
+	}
+	for _, query := range []string{"source=ftp", "from=yesterday", "from=2019-04-02T00:00:00Z&to=2019-04-01T00:00:00Z"} {
+		req := httptest.NewRequest("POST", "/revdns/api/v1/purge?"+query, nil)
+		if _, _, err := newPurge(req); err == nil {
+			T.Errorf("Invalid purge %q accepted", query)
+		}
+	}
+}
+
+func TestCidrScan(T *testing.T) {
+	_, n, _ := net.ParseCIDR("10.1.1.254/31")
+	ips, err := cidrAddrs(n, 16)
+	if err != nil || len(ips) != 2 || ips[0] != "10.1.1.254" || ips[1] != "10.1.1.255" {
+		T.Errorf("Invalid addresses: %v %v", ips, err)
+	}
+	for _, cidr := range []string{"10.0.0.0/8", "2001:db8::/64"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		if _, err := cidrAddrs(n, 1024); err == nil {
+			T.Errorf("Large prefix %s accepted", cidr)
+		}
+	}
+
+	val := revdb.DnsVal{Domains: map[string]revdb.DnsInfo{
+		"a.com": {Intervals: []revdb.Interval{{From: 100, To: 200}}},
+		"b.com": {Intervals: []revdb.Interval{{From: 100, To: 100}}},
+	}}
+	if !unranked(val, 150) || unranked(val, 300) {
+		T.Errorf("Invalid recent unranked match")
+	}
+	val.Domains["c.com"] = revdb.DnsInfo{WlId: 10}
+	if unranked(val, 150) {
+		T.Errorf("Ranked domain matched")
+	}
+}
+
+func TestCofRecord(T *testing.T) {
+	info := revdb.DnsInfo{Count: 3, FirstSeen: 100, LastSeen: 200, Sensors: []string{"s1", "s2"}}
+	rec, _ := newCofRecord("10.1.1.1", "a.com", info)
+	data, _ := json.Marshal(rec)
+	want := `{"rrname":"a.com","rrtype":"A","rdata":"10.1.1.1","time_first":100,"time_last":200,"count":3,"sensor_id":"s1,s2"}`
+	if string(data) != want {
+		T.Errorf("Invalid COF record: %s", data)
+	}
+	if rec, _ := newCofRecord("2001:db8::1", "a.com", info); rec.RRType != "AAAA" {
+		T.Errorf("Invalid rrtype: %s", rec.RRType)
+	}
+	if _, ok := newCofRecord("10.1.1.1", "a.com", revdb.DnsInfo{Count: 1}); ok {
+		T.Errorf("Record without times")
+	}
+	info = revdb.DnsInfo{Intervals: []revdb.Interval{{From: 100, To: 150}, {From: 180, To: 200}}}
+	if rec, ok := newCofRecord("10.1.1.1", "a.com", info); !ok || rec.TimeFirst != 100 || rec.TimeLast != 200 {
+		T.Errorf("Invalid interval times: %+v", rec)
+	}
+}
+
+func TestFormats(T *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                  mimeJSON,
+		"*/*":                               mimeJSON,
+		"text/csv":                          mimeCSV,
+		"application/json;q=0.5, text/csv":  mimeCSV,
+		"application/stix+json;version=2.1": mimeSTIX,
+		"application/xml, " + mimeMISP:      mimeMISP,
+	} {
+		if got, err := negotiate(accept); err != nil || got != want {
+			T.Errorf("Invalid format for %q: %s %v", accept, got, err)
+		}
+	}
+	if _, err := negotiate("application/xml"); err != errNotAcceptable {
+		T.Errorf("Unsupported format accepted")
+	}
+
+	dnsNamespace := [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1,
+		0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	if u := uuid5(dnsNamespace, "www.example.com"); u != "2ed6657d-e927-568b-95e1-2665a8aea6a2" {
+		T.Errorf("Invalid uuid5: %s", u)
+	}
+
+	rec := revdb.Record{IP: "10.1.1.1", DnsVal: revdb.DnsVal{Domains: map[string]revdb.DnsInfo{
+		"a.com": {WlId: 5, Sources: []string{"dns", "ssl"}, Count: 2},
+		"b.com": {},
+	}}}
+	w := httptest.NewRecorder()
+	enc := newRecordEncoder(mimeSTIX, w, "test")
+	enc.write(rec)
+	enc.write(revdb.Record{IP: "10.1.1.2", DnsVal: revdb.DnsVal{Domains: map[string]revdb.DnsInfo{"a.com": {}}}})
+	enc.close()
+	var bundle struct {
+		Type    string
+		Objects []map[string]string
+	}
+	if err := json.NewDecoder(w.Body).Decode(&bundle); err != nil || bundle.Type != "bundle" {
+		T.Fatalf("Invalid bundle: %v", err)
+	}
+	//2 ips, 2 domains, 3 relationships
+	if len(bundle.Objects) != 7 {
+		T.Errorf("Invalid bundle objects: %v", bundle.Objects)
+	}
+
+	w = httptest.NewRecorder()
+	enc = newRecordEncoder(mimeCSV, w, "test")
+	enc.write(rec)
+	enc.close()
+	want := "ip,domain,wlid,sources,sensors,count,first_seen,last_seen\n" +
+		"10.1.1.1,a.com,5,dns;ssl,,2,0,0\n10.1.1.1,b.com,0,,,0,0,0\n"
+	if w.Body.String() != want {
+		T.Errorf("Invalid csv: %q", w.Body.String())
+	}
+}
+
+func TestRateLimit(T *testing.T) {
+	l := newLimiter(revconfig.RateLimit{
+		Rate:   1,
+		Burst:  2,
+		Quotas: map[string]revconfig.Quota{"etl": {Rate: 0}},
+	})
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	key, q := l.clientKey(nil, "10.1.1.1:5000")
+	if key != "ip:10.1.1.1" {
+		T.Errorf("Invalid client key %s", key)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow(key, q); !ok {
+			T.Errorf("Burst request %d throttled", i)
+		}
+	}
+	ok, wait := l.allow(key, q)
+	if ok || wait != time.Second || retryAfter(wait) != "1" {
+		T.Errorf("Request over burst allowed: %t %s", ok, wait)
+	}
+	now = now.Add(time.Second)
+	if ok, _ := l.allow(key, q); !ok {
+		T.Errorf("Refilled request throttled")
+	}
+
+	//other clients and unlimited quotas aren't affected
+	if ok, _ := l.allow(l.clientKey(nil, "10.1.1.2:5000")); !ok {
+		T.Errorf("Other client throttled")
+	}
+	etl := &auth.Identity{Name: "ETL", Method: auth.MethodKey}
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow(l.clientKey(etl, "10.1.1.1:5000")); !ok {
+			T.Errorf("Unlimited quota throttled")
+		}
+	}
+
+	//busy clients don't grow the buckets past size
+	l.size = 2
+	for i := 0; i < 5; i++ {
+		key, q := l.clientKey(nil, fmt.Sprintf("10.2.0.%d:5000", i))
+		l.allow(key, q)
+		l.allow(key, q)
+	}
+	if len(l.buckets) != 2 || l.ll.Len() != 2 {
+		T.Errorf("Buckets over size: %d", len(l.buckets))
+	}
+	if newLimiter(revconfig.RateLimit{}) != nil {
+		T.Errorf("Limiter without rate enabled")
+	}
+}
+
+func TestDeniedThrottle(T *testing.T) {
+	conf := &revconfig.RevConfig{
+		Auth: revconfig.AuthConfig{
+			Enabled: true,
+			Keys:    []revconfig.AuthKey{{Name: "soc", Key: "k1", Role: auth.RoleReader}},
+		},
+	}
+	conf.Api.RateLimit = revconfig.RateLimit{Rate: 1, Burst: 2}
+	r := NewRevDns(conf)
+	router := mux.NewRouter()
+	r.route(router, "/read", auth.RoleReader, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}), "GET")
+	get := func(addr string) int {
+		req := httptest.NewRequest("GET", "/revdns/api/v1/read", nil)
+		req.Header.Set("X-Api-Key", "bad")
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if code := get("10.1.1.1:5000"); code != want {
+			T.Errorf("Denied request %d: %d, expected %d", i, code, want)
+		}
+	}
+	if code := get("10.1.1.2:5000"); code != http.StatusUnauthorized {
+		T.Errorf("Other address throttled: %d", code)
+	}
+}
+
+func TestAuditLog(T *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	r := NewRevDns(&revconfig.RevConfig{Audit: revconfig.AuditConfig{Enabled: true, Output: "file", File: path}})
+	router := mux.NewRouter()
+	r.route(router, "/ip/{ip}", auth.RoleReader, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auditResults(req, 3)
+		auditQueries(req, []string{"10.1.1.1", "10.1.1.2"})
+	}), "GET")
+	req := httptest.NewRequest("GET", "/revdns/api/v1/ip/10.1.1.1?source=dns", nil)
+	req.RemoteAddr = "192.168.1.5:4000"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	r.auditLog.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		T.Fatal(err)
+	}
+	var e auditEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		T.Fatalf("Invalid entry %q: %s", data, err)
+	}
+	if e.Identity != "anonymous" || e.Client != "192.168.1.5" || e.Query != "10.1.1.1" ||
+		e.Params != "source=dns" || e.Results != 3 || e.Status != http.StatusOK || e.Tenant != defaultTenant {
+		T.Errorf("Invalid entry: %s", data)
+	}
+	if e.QueryCount != 2 || len(e.QueryHash) != 64 {
+		T.Errorf("Invalid bulk queries: %s", data)
+	}
+}
+
+func TestRouteTimeout(T *testing.T) {
+	r := NewRevDns(&revconfig.RevConfig{Api: revconfig.RevAPI{Timeout: time.Minute}})
+	router := mux.NewRouter()
+	deadline := func(w http.ResponseWriter, req *http.Request) {
+		_, ok := req.Context().Deadline()
+		fmt.Fprint(w, ok)
+	}
+	r.route(router, "/domain/{domain}", auth.RoleReader, http.HandlerFunc(deadline), "GET")
+	r.routeUntimed(router, "/feed", auth.RoleReader, http.HandlerFunc(deadline), "GET")
+	for path, want := range map[string]string{
+		"/feed":           "false",
+		"/t/default/feed": "false",
+		"/domain/feed":    "true",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/revdns/api/v1"+path, nil))
+		if w.Body.String() != want {
+			T.Errorf("Deadline of %s: %s", path, w.Body.String())
+		}
+	}
+}
+
+func TestAuthorize(T *testing.T) {
+	conf := &revconfig.RevConfig{
+		Auth: revconfig.AuthConfig{
+			Enabled: true,
+			Keys: []revconfig.AuthKey{
+				{Name: "soc", Key: "k1", Role: auth.RoleReader},
+				{Name: "ops", Key: "k2", Role: auth.RoleAdmin, Tenants: []string{"default", "acme"}},
+			},
+		},
+		Tenants: map[string]revconfig.TenantConfig{
+			"acme": {ApiKeys: []string{"k3"}, Role: auth.RoleReader},
+		},
+	}
+	r := NewRevDns(conf)
+	router := mux.NewRouter()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, identityOf(req).Name)
+	})
+	r.route(router, "/read", auth.RoleReader, ok, "GET")
+	r.route(router, "/admin", auth.RoleAdmin, ok, "GET")
+
+	for _, c := range []struct {
+		path string
+		key  string
+		code int
+	}{
+		{"/read", "", http.StatusUnauthorized},
+		{"/read", "bad", http.StatusUnauthorized},
+		{"/read", "k1", http.StatusOK},
+		{"/admin", "k1", http.StatusForbidden},
+		{"/admin", "k2", http.StatusOK},
+		{"/t/acme/read", "k1", http.StatusForbidden},
+		{"/t/acme/read", "k3", http.StatusOK},
+		{"/t/acme/admin", "k3", http.StatusForbidden},
+		{"/t/acme/admin", "k2", http.StatusOK},
+		{"/read", "k3", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/revdns/api/v1"+c.path, nil)
+		if c.key != "" {
+			req.Header.Set("X-Api-Key", c.key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != c.code {
+			T.Errorf("%s with %q: %d, expected %d", c.path, c.key, w.Code, c.code)
+		}
+	}
+	if n := r.denialCounts()["soc"]; n != 2 {
+		T.Errorf("Invalid denial count for soc: %d", n)
+	}
+	//without auth anonymous callers only read
+	conf.Auth.Enabled = false
+	r = NewRevDns(conf)
+	router = mux.NewRouter()
+	r.route(router, "/read", auth.RoleReader, ok, "GET")
+	r.route(router, "/admin", auth.RoleAdmin, ok, "GET")
+	for path, code := range map[string]int{"/read": http.StatusOK, "/admin": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/revdns/api/v1"+path, nil))
+		if w.Code != code {
+			T.Errorf("Anonymous %s: %d, expected %d", path, w.Code, code)
+		}
+	}
+}
+
+//writeCert writes a self signed certificate for cn to dir
+func writeCert(T *testing.T, dir string, cn string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		T.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		T.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		T.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestCertReload(T *testing.T) {
+	dir, err := ioutil.TempDir("", "revdns")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(T, dir, "one")
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		T.Fatal(err)
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	cn := func() string {
+		cert, _ := c.GetCertificate(nil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			T.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if name := cn(); name != "one" {
+		T.Errorf("Invalid certificate: %s", name)
+	}
+
+	writeCert(T, dir, "two")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if name := cn(); name != "one" {
+		T.Errorf("Reloaded before the check interval: %s", name)
+	}
+	now = now.Add(certCheckInterval)
+	if name := cn(); name != "two" {
+		T.Errorf("Certificate not reloaded: %s", name)
+	}
+
+	//a broken pair keeps the old certificate
+	ioutil.WriteFile(keyFile, []byte("junk"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	now = now.Add(certCheckInterval)
+	if name := cn(); name != "two" {
+		T.Errorf("Broken certificate loaded: %s", name)
+	}
+}
+
+type fakeInput struct {
+	connected bool
+	err       error
+	lag       int64
+}
+
+func (f *fakeInput) status() (bool, error, int64) {
+	return f.connected, f.err, f.lag
+}
+
+func TestReadyz(T *testing.T) {
+	conf := &revconfig.RevConfig{}
+	conf.Kafka.MaxLag = 100
+	r := NewRevDns(conf)
+	ready := func() (int, map[string]componentStatus) {
+		w := httptest.NewRecorder()
+		r.readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		var body struct {
+			Components map[string]componentStatus
+		}
+		json.NewDecoder(w.Body).Decode(&body)
+		return w.Code, body.Components
+	}
+
+	code, comps := ready()
+	if code != http.StatusServiceUnavailable || comps["db"].Status != statusUnavailable {
+		T.Errorf("Ready without db: %d %v", code, comps)
+	}
+
+	r.dbs[defaultTenant] = revdb.NewCachedDB(&revdb.BoltDB{}, 1, 1, time.Minute)
+	in := &fakeInput{err: fmt.Errorf("connection refused")}
+	r.input = in
+	if code, comps = ready(); code != http.StatusServiceUnavailable || comps["input"].Status != statusUnavailable {
+		T.Errorf("Ready without kafka: %d %v", code, comps)
+	}
+	//the bolt db has no whitelist
+	if comps["whitelist"].Status != statusUnavailable {
+		T.Errorf("Ready without whitelist: %v", comps)
+	}
+
+	in.connected, in.lag = true, 1000
+	if _, comps = ready(); comps["input"].Status != statusUnavailable || *comps["input"].Lag != 1000 {
+		T.Errorf("Ready while lagging: %v", comps)
+	}
+	in.lag = 10
+	if _, comps = ready(); comps["input"].Status != statusOK {
+		T.Errorf("Input not ready: %v", comps)
+	}
+}
+
+func TestZeekFailed(T *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Trailer", zeekErrorTrailer)
+	fmt.Fprintln(w, "1.0\tC1")
+	zeekFailed(w, false, fmt.Errorf("db closed"))
+	rsp := w.Result()
+	body, _ := ioutil.ReadAll(rsp.Body)
+	if string(body) != "1.0\tC1\n#error\tdb closed\n" {
+		T.Errorf("Invalid failed log: %q", body)
+	}
+	if rsp.Trailer.Get(zeekErrorTrailer) != "db closed" {
+		T.Errorf("Missing error trailer: %v", rsp.Trailer)
+	}
+}
+
+func TestTenantDB(T *testing.T) {
+	dir, err := ioutil.TempDir("", "tenant")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	r := NewRevDns(&revconfig.RevConfig{
+		Tenants: map[string]revconfig.TenantConfig{"acme": {}, "beta": {}},
+	})
+	defer r.closeDBs()
+	//don't download the whitelist
+	shared := &wl.WhitelistDB{}
+	r.wlOnce.Do(func() { r.wl = shared })
+
+	dbs := make(chan revdb.DBIface, 10)
+	for i := 0; i < cap(dbs); i++ {
+		go func(tenant string) {
+			db, err := r.tenantDB(tenant)
+			if err != nil {
+				T.Errorf("Error opening %s: %s", tenant, err)
+			}
+			dbs <- db
+		}([]string{"acme", "beta"}[i%2])
+	}
+	opened := make(map[revdb.DBIface]bool)
+	for i := 0; i < cap(dbs); i++ {
+		opened[<-dbs] = true
+	}
+	if len(opened) != 2 || len(r.opening) != 0 {
+		T.Errorf("Tenant dbs opened more than once: %d", len(opened))
+	}
+	if _, err := r.tenantDB(defaultTenant); err != errDBUnavailable {
+		T.Errorf("Default db opened by tenantDB: %v", err)
+	}
+}
+
+func TestZeekUpload(T *testing.T) {
+	dir, err := ioutil.TempDir("", "zeek")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := revdb.OpenBoltDB(filepath.Join(dir, "zeek.db"), false)
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer db.Close()
+	db.WriteDB("10.1.1.1", []string{"a.example.com"}, revdb.Evidence{Source: revdb.SourceDNS})
+	r := NewRevDns(&revconfig.RevConfig{})
+	r.dbs[defaultTenant] = db
+	srv := httptest.NewServer(http.HandlerFunc(r.zeekHandler))
+	defer srv.Close()
+
+	//several enrichment batches, the body is read while the answer streams
+	var conn bytes.Buffer
+	conn.WriteString("#fields\tts\tid.orig_h\tid.resp_h\n")
+	const lines = 3000
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&conn, "%d.0\t192.168.1.1\t10.1.%d.%d\n", i, i/256%256, i%256)
+	}
+	upload := func(chunked bool) {
+		var body io.Reader = bytes.NewReader(conn.Bytes())
+		if chunked {
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(func() error {
+					_, err := io.Copy(pw, bytes.NewReader(conn.Bytes()))
+					return err
+				}())
+			}()
+			body = pr
+		}
+		rsp, err := http.Post(srv.URL, "text/plain", body)
+		if err != nil {
+			T.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		out, err := ioutil.ReadAll(rsp.Body)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if n := bytes.Count(out, []byte("\n")); n != lines+1 || bytes.Contains(out, []byte("#error")) {
+			T.Errorf("Upload chunked=%t cut short after %d lines: %q", chunked, n, out[len(out)-100:])
+		}
+		if !bytes.Contains(out, []byte("\t10.1.1.1\ta.example.com\t")) {
+			T.Errorf("Upload chunked=%t not enriched", chunked)
+		}
+		if e := rsp.Trailer.Get(zeekErrorTrailer); e != "" {
+			T.Errorf("Upload chunked=%t failed: %s", chunked, e)
+		}
+	}
+	upload(false)
+	upload(true)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gviz/revDNS/internal/revdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//latencyBuckets in seconds, lookups are often served under a millisecond
+var latencyBuckets = append([]float64{.0005, .001}, prometheus.DefBuckets...)
+
+var (
+	apiLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "revdns_api_request_seconds",
+		Help:    "Latency of api requests by route.",
+		Buckets: latencyBuckets,
+	}, []string{"route", "method", "code"})
+	kafkaMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "revdns_kafka_messages_total",
+		Help: "Input records consumed by stream.",
+	}, []string{"stream"})
+	parseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "revdns_parse_errors_total",
+		Help: "Input records that couldn't be parsed by stream, empty for records without one.",
+	}, []string{"stream"})
+)
+
+//sample a value of a funcCollector and its label values
+type sample struct {
+	labels []string
+	value  float64
+}
+
+//funcCollector reports the samples of fn on every scrape, for values
+//read from state that is labelled by tenant or queue
+type funcCollector struct {
+	desc *prometheus.Desc
+	typ  prometheus.ValueType
+	fn   func() []sample
+}
+
+func newFuncCollector(name string, help string, typ prometheus.ValueType,
+	fn func() []sample, labels ...string) *funcCollector {
+	c := &funcCollector{
+		desc: prometheus.NewDesc(name, help, labels, nil),
+		typ:  typ,
+		fn:   fn,
+	}
+	prometheus.MustRegister(c)
+	return c
+}
+
+func (c *funcCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *funcCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.fn() {
+		ch <- prometheus.MustNewConstMetric(c.desc, c.typ, s.value, s.labels...)
+	}
+}
+
+//statusWriter records the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+//Flush keeps streamed responses streaming
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//Hijack keeps connection upgrades working
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+//instrument records the latency of requests to route
+func instrument(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, req)
+		apiLatency.WithLabelValues(route, req.Method, strconv.Itoa(sw.code)).Observe(time.Since(start).Seconds())
+	})
+}
+
+//backend returns the db under the cache
+func backend(db revdb.DBIface) revdb.DBIface {
+	if c, ok := db.(*revdb.CachedDB); ok {
+		return c.DBIface
+	}
+	return db
+}
+
+//registerMetrics adds the metrics read from r's state on every scrape
+func (r *revDns) registerMetrics() {
+	newFuncCollector("revdns_queue_length", "Requests waiting in the db handler queues.",
+		prometheus.GaugeValue, func() []sample {
+			return []sample{
+				{labels: []string{"writer"}, value: float64(len(r.writer))},
+				{labels: []string{"lookup"}, value: float64(len(r.httpReq))},
+			}
+		}, "queue")
+	newFuncCollector("revdns_queue_capacity", "Capacity of the db handler queues.",
+		prometheus.GaugeValue, func() []sample {
+			return []sample{
+				{labels: []string{"writer"}, value: float64(cap(r.writer))},
+				{labels: []string{"lookup"}, value: float64(cap(r.httpReq))},
+			}
+		}, "queue")
+	newFuncCollector("revdns_whitelist_entries", "Whitelisted domains by tenant.",
+		prometheus.GaugeValue, func() []sample {
+			var samples []sample
+			r.dbLock.Lock()
+			defer r.dbLock.Unlock()
+			for tenant, db := range r.dbs {
+				if w, ok := backend(db).(interface{ WhitelistSize() int }); ok {
+					samples = append(samples, sample{
+						labels: []string{tenant},
+						value:  float64(w.WhitelistSize()),
+					})
+				}
+			}
+			return samples
+		}, "tenant")
+
+	cacheCounter := func(name string, help string, field func(s revdb.CacheStats) float64) {
+		newFuncCollector(name, help, prometheus.CounterValue, func() []sample {
+			var samples []sample
+			for tenant, s := range r.cacheStats() {
+				samples = append(samples, sample{
+					labels: []string{tenant},
+					value:  field(s),
+				})
+			}
+			return samples
+		}, "tenant")
+	}
+	cacheCounter("revdns_cache_hits_total", "Lookups served from the cache.",
+		func(s revdb.CacheStats) float64 { return float64(s.Hits) })
+	cacheCounter("revdns_cache_misses_total", "Lookups read from the db.",
+		func(s revdb.CacheStats) float64 { return float64(s.Misses) })
+	cacheCounter("revdns_cache_negative_hits_total", "Unknown ips served from the negative cache.",
+		func(s revdb.CacheStats) float64 { return float64(s.NegativeHits) })
+	cacheCounter("revdns_cache_evictions_total", "Entries evicted from the cache.",
+		func(s revdb.CacheStats) float64 { return float64(s.Evictions) })
+	newFuncCollector("revdns_cache_entries", "Entries in the cache.",
+		prometheus.GaugeValue, func() []sample {
+			var samples []sample
+			for tenant, s := range r.cacheStats() {
+				samples = append(samples,
+					sample{labels: []string{tenant, "positive"}, value: float64(s.Entries)},
+					sample{labels: []string{tenant, "negative"}, value: float64(s.NegEntries)})
+			}
+			return samples
+		}, "tenant", "cache")
+
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "revdns_auth_denials_total",
+		Help: "Denied api requests.",
+	}, func() float64 {
+		var total uint64
+		for _, n := range r.denialCounts() {
+			total += n
+		}
+		return float64(total)
+	})
+}
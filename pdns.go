@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+//cofRecord a passive DNS Common Output Format record,
+//draft-dulaunoy-dnsop-passive-dns-cof
+type cofRecord struct {
+	RRName    string `json:"rrname"`
+	RRType    string `json:"rrtype"`
+	RData     string `json:"rdata"`
+	TimeFirst int64  `json:"time_first"`
+	TimeLast  int64  `json:"time_last"`
+	Count     int    `json:"count,omitempty"`
+	//sensors that saw the pair, comma separated
+	SensorID string `json:"sensor_id,omitempty"`
+}
+
+//newCofRecord returns the record of a pair, ok is false for pairs
+//without observation times, they would read as seen in 1970
+func newCofRecord(ip string, domain string, info revdb.DnsInfo) (cofRecord, bool) {
+	first, last := info.FirstSeen, info.LastSeen
+	if n := len(info.Intervals); n > 0 {
+		if first == 0 {
+			first = info.Intervals[0].From
+		}
+		if last == 0 {
+			last = info.Intervals[n-1].To
+		}
+	}
+	if first == 0 || last == 0 {
+		return cofRecord{}, false
+	}
+	rrtype := "AAAA"
+	if addr := net.ParseIP(ip); addr != nil && addr.To4() != nil {
+		rrtype = "A"
+	}
+	return cofRecord{
+		RRName:    domain,
+		RRType:    rrtype,
+		RData:     ip,
+		TimeFirst: first,
+		TimeLast:  last,
+		Count:     info.Count,
+		SensorID:  strings.Join(info.Sensors, ","),
+	}, true
+}
+
+//pdnsHandler answers a passive DNS query for an ip or a domain with
+//NDJSON COF records, one per ip/domain pair. Only pairs from dns answers
+//are answered unless ?source= asks for another source, sni and host
+//header pairs aren't A/AAAA records anyone resolved.
+func (r *revDns) pdnsHandler(w http.ResponseWriter, req *http.Request) {
+	value := mux.Vars(req)["value"]
+	filter, err := newLkupFilter(req.URL.Query())
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	if filter.source == "" {
+		filter.source = revdb.SourceDNS
+	}
+
+	var recs []cofRecord
+	if addr := net.ParseIP(value); addr != nil {
+		ip := addr.String()
+		rsp := submit(r.httpReq, lkupReq{
+			ctx:    req.Context(),
+			tenant: tenantOf(req),
+			ip:     ip,
+			filter: filter,
+		})
+		if rsp.err != nil {
+			writeError(w, rsp.err)
+			return
+		}
+		for name, info := range rsp.val.Domains {
+			if rec, ok := newCofRecord(ip, name, info); ok {
+				recs = append(recs, rec)
+			}
+		}
+	} else {
+		domain := normalizeDomain(value)
+		if domain == "" {
+			badRequest(w, "invalid domain")
+			return
+		}
+		ips, err := r.lookupDomain(tenantOf(req), domain)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for ip, info := range ips {
+			if !filter.match(domain, info) {
+				continue
+			}
+			if rec, ok := newCofRecord(ip, domain, info); ok {
+				recs = append(recs, rec)
+			}
+		}
+	}
+	if len(recs) == 0 {
+		writeError(w, newAPIError(http.StatusNotFound, "no records for %s", value))
+		return
+	}
+	auditResults(req, len(recs))
+	//most recently seen first
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].TimeLast != recs[j].TimeLast {
+			return recs[i].TimeLast > recs[j].TimeLast
+		}
+		return recs[i].RRName+recs[i].RData < recs[j].RRName+recs[j].RData
+	})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return
+		}
+	}
+}
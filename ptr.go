@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+
+	"github.com/gviz/revDNS/internal/ptrsrv"
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+//resolvePTR returns the PTR names of ip, best ranked first. Names learned
+//from DNS answers are preferred over client supplied SNI/Host names.
+func (r *revDns) resolvePTR(ip string) ([]string, bool, error) {
+	db, err := r.tenantDB(defaultTenant)
+	if err != nil {
+		return nil, false, err
+	}
+	val, err := db.ReadDB(ip)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(val.Domains) == 0 {
+		return nil, false, nil
+	}
+	dns := val.Filter(func(name string, info revdb.DnsInfo) bool {
+		return info.HasSource(revdb.SourceDNS)
+	})
+	if len(dns.Domains) > 0 {
+		val = dns
+	}
+	names := val.Ranked()
+	if !r.conf.DNS.All {
+		names = names[:1]
+	}
+	return names, true, nil
+}
+
+//ptrHandler serves PTR queries for the default tenant
+func (r *revDns) ptrHandler() {
+	s := &ptrsrv.Server{
+		Addr:    r.conf.DNS.Listen,
+		TTL:     uint32(r.conf.DNS.TTL.Seconds()),
+		Resolve: r.resolvePTR,
+	}
+	log.Printf("Serving PTR queries on %s", s.Addr)
+	log.Fatal(s.ListenAndServe())
+}
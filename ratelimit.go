@@ -0,0 +1,204 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gviz/revDNS/internal/auth"
+	"github.com/gviz/revDNS/internal/revconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//maxRateBuckets clients tracked, the least recently seen client's bucket
+//is dropped for a new one
+const maxRateBuckets = 10000
+
+var throttled = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "revdns_api_throttled_total",
+	Help: "Api requests rejected by the rate limiter by route.",
+}, []string{"route"})
+
+//bucket a client's token bucket
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+//limiter token buckets per client. Clients are identified by name,
+//anonymous callers by address. Buckets are kept in lru order.
+type limiter struct {
+	quota  revconfig.Quota
+	quotas map[string]revconfig.Quota
+	now    func() time.Time
+	size   int
+
+	lock    sync.Mutex
+	ll      *list.List
+	buckets map[string]*list.Element
+}
+
+//newLimiter returns nil when rate limiting is disabled
+func newLimiter(conf revconfig.RateLimit) *limiter {
+	if conf.Rate <= 0 && len(conf.Quotas) == 0 {
+		return nil
+	}
+	l := &limiter{
+		quota:   revconfig.Quota{Rate: conf.Rate, Burst: conf.Burst},
+		quotas:  make(map[string]revconfig.Quota),
+		now:     time.Now,
+		size:    maxRateBuckets,
+		ll:      list.New(),
+		buckets: make(map[string]*list.Element),
+	}
+	for name, q := range conf.Quotas {
+		l.quotas[strings.ToLower(name)] = q
+	}
+	return l
+}
+
+//clientKey names the bucket of a caller and returns its quota
+func (l *limiter) clientKey(id *auth.Identity, remoteAddr string) (string, revconfig.Quota) {
+	if id != nil && id.Method != auth.MethodNone {
+		if q, ok := l.quotas[strings.ToLower(id.Name)]; ok {
+			return "id:" + id.Name, q
+		}
+		return "id:" + id.Name, l.quota
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return "ip:" + host, l.quota
+}
+
+//allow takes a token from key's bucket, or returns how long until one
+//is available. A quota rate of 0 is unlimited, the burst defaults to
+//a second's worth of requests.
+func (l *limiter) allow(key string, q revconfig.Quota) (bool, time.Duration) {
+	if q.Rate <= 0 {
+		return true, 0
+	}
+	burst := float64(q.Burst)
+	if burst < 1 {
+		burst = math.Max(1, q.Rate)
+	}
+	now := l.now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	b := l.bucket(key, burst, now)
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*q.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / q.Rate * float64(time.Second))
+	return false, wait
+}
+
+//denied takes a token from host's bucket of denied requests, which
+//refills at the default quota
+func (l *limiter) denied(host string) {
+	l.allow("denied:"+host, l.quota)
+}
+
+//deniedWait returns how long host's denied requests are throttled for,
+//0 if host isn't over the default quota. No bucket is added for hosts
+//without denials.
+func (l *limiter) deniedWait(host string) time.Duration {
+	if l.quota.Rate <= 0 {
+		return 0
+	}
+	now := l.now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e, ok := l.buckets["denied:"+host]
+	if !ok {
+		return 0
+	}
+	b := e.Value.(*bucket)
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.quota.Rate
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / l.quota.Rate * float64(time.Second))
+}
+
+//bucket returns key's bucket, adding a full one and evicting the least
+//recently seen clients over size. Those have had the longest to refill.
+func (l *limiter) bucket(key string, burst float64, now time.Time) *bucket {
+	if e, ok := l.buckets[key]; ok {
+		l.ll.MoveToFront(e)
+		return e.Value.(*bucket)
+	}
+	b := &bucket{key: key, tokens: burst, last: now}
+	l.buckets[key] = l.ll.PushFront(b)
+	for l.ll.Len() > l.size {
+		e := l.ll.Back()
+		l.ll.Remove(e)
+		delete(l.buckets, e.Value.(*bucket).key)
+	}
+	return b
+}
+
+//errRateLimited answer of throttled requests
+func errRateLimited(wait time.Duration) *apiError {
+	return newAPIError(http.StatusTooManyRequests, "rate limited, retry in %s", wait.Round(time.Millisecond))
+}
+
+//retryAfter the Retry-After seconds of wait, at least one
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(math.Max(wait.Seconds(), 1))))
+}
+
+//rateLimit throttles authorized callers of route
+func (r *revDns) rateLimit(route string, next http.Handler) http.Handler {
+	if r.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key, q := r.limiter.clientKey(identityOf(req), req.RemoteAddr)
+		if ok, wait := r.limiter.allow(key, q); !ok {
+			throttled.WithLabelValues(route).Inc()
+			w.Header().Set("Retry-After", retryAfter(wait))
+			writeError(w, errRateLimited(wait))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+//deniedWait returns how long callers from addr are refused for because
+//their requests were denied faster than the default quota. Floods of
+//bad credentials are cut off before the credentials are checked.
+func (r *revDns) deniedWait(addr string) time.Duration {
+	if r.limiter == nil {
+		return 0
+	}
+	wait := r.limiter.deniedWait(clientHost(addr))
+	if wait > 0 {
+		throttled.WithLabelValues("denied").Inc()
+	}
+	return wait
+}
+
+//throttle applies the rate limit to a gRPC caller
+func (r *revDns) throttle(id *auth.Identity, addr string, method string) error {
+	if r.limiter == nil {
+		return nil
+	}
+	key, q := r.limiter.clientKey(id, addr)
+	if ok, wait := r.limiter.allow(key, q); !ok {
+		throttled.WithLabelValues("grpc " + method).Inc()
+		return errRateLimited(wait)
+	}
+	return nil
+}
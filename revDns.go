@@ -1,79 +1,390 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
+	"regexp"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gviz/revDNS/internal/audit"
+	"github.com/gviz/revDNS/internal/auth"
 	"github.com/gviz/revDNS/internal/revconfig"
 	"github.com/gviz/revDNS/internal/revdb"
+	"github.com/gviz/revDNS/internal/wl"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const (
+	defaultTenant = "default"
+	dbRetry       = 5 * time.Second
+)
+
+var tenantName = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
 type writeReq struct {
+	tenant  string
 	ip      string
 	domains []string
+	ev      revdb.Evidence
 	c       chan struct{}
 }
 
+//dbOpen a tenant db being opened, done is closed once db or err is set
+type dbOpen struct {
+	done chan struct{}
+	db   revdb.DBIface
+	err  error
+}
+
 type revDns struct {
 	httpReq chan lkupReq
 	writer  chan writeReq
-	db      revdb.DBIface
+	dbLock  sync.Mutex
+	dbs     map[string]revdb.DBIface
+	opening map[string]*dbOpen
+	//whitelist shared by the tenant dbs, loaded by the first open
+	wlOnce  sync.Once
+	wl      *wl.WhitelistDB
+	feed    *feed
+	input   inputStatus
 	conf    *revconfig.RevConfig
+	tlsConf *tls.Config
+
+	auth     *auth.Authenticator
+	authLock sync.Mutex
+	denials  map[string]uint64
+	limiter  *limiter
+	auditLog *audit.Logger
 }
 
 func NewRevDns(conf *revconfig.RevConfig) *revDns {
+	a, err := newAuthenticator(conf)
+	if err != nil {
+		log.Fatal("Error setting up auth: ", err)
+	}
+	auditLog, err := newAuditLogger(conf.Audit)
+	if err != nil {
+		log.Fatal("Error opening audit log: ", err)
+	}
 	return &revDns{
-		httpReq: make(chan lkupReq, 50000),
-		writer:  make(chan writeReq, 50000),
-		conf:    conf,
+		httpReq:  make(chan lkupReq, 50000),
+		writer:   make(chan writeReq, 50000),
+		dbs:      make(map[string]revdb.DBIface),
+		opening:  make(map[string]*dbOpen),
+		feed:     newFeed(),
+		conf:     conf,
+		auth:     a,
+		denials:  make(map[string]uint64),
+		limiter:  newLimiter(conf.Api.RateLimit),
+		auditLog: auditLog,
+	}
+}
+
+//validTenant reports whether data for tenant may be stored/served.
+//Tenant names are lower case, viper folds config keys.
+func (r *revDns) validTenant(tenant string) bool {
+	if tenant == defaultTenant {
+		return true
+	}
+	_, ok := r.conf.Tenants[tenant]
+	return ok && tenantName.MatchString(tenant)
+}
+
+//tenantDB returns the db for a tenant, each tenant is kept in its own
+//file. The default tenant's db is opened by dbHandler. Other tenants'
+//dbs are opened on first use outside dbLock, concurrent requests for
+//the tenant wait for the same open.
+func (r *revDns) tenantDB(tenant string) (revdb.DBIface, error) {
+	if !r.validTenant(tenant) {
+		return nil, newAPIError(http.StatusNotFound, "unknown tenant %q", tenant)
+	}
+	r.dbLock.Lock()
+	if db, ok := r.dbs[tenant]; ok {
+		r.dbLock.Unlock()
+		return db, nil
+	}
+	if tenant == defaultTenant {
+		r.dbLock.Unlock()
+		return nil, errDBUnavailable
+	}
+	o, pending := r.opening[tenant]
+	if !pending {
+		o = &dbOpen{done: make(chan struct{})}
+		r.opening[tenant] = o
+	}
+	r.dbLock.Unlock()
+
+	if !pending {
+		o.db, o.err = r.openDB(tenant)
+		r.dbLock.Lock()
+		if o.err == nil {
+			r.dbs[tenant] = o.db
+		}
+		delete(r.opening, tenant)
+		r.dbLock.Unlock()
+		close(o.done)
+		if o.err != nil {
+			log.Println("Error opening DB:", o.err)
+		}
+	}
+	<-o.done
+	if o.err != nil {
+		return nil, errDBUnavailable
+	}
+	return o.db, nil
+}
+
+//whitelist returns the whitelist shared by the tenant dbs, loading it
+//on first use
+func (r *revDns) whitelist() *wl.WhitelistDB {
+	r.wlOnce.Do(func() {
+		r.wl = revdb.DefaultWhitelist()
+	})
+	return r.wl
+}
+
+func (r *revDns) openDB(tenant string) (revdb.DBIface, error) {
+	file := "revdb"
+	if tenant != defaultTenant {
+		file = "revdb-" + tenant
+	}
+	bolt, err := revdb.CreateBoltDB("./", file, tenant, r.whitelist())
+	if err != nil {
+		return nil, err
+	}
+	bolt.SetLimits(r.limits())
+	bolt.SetEncoding(revdb.Encoding{
+		Binary:        r.conf.DB.Encoding == "binary",
+		CompressAbove: r.conf.DB.CompressAbove,
+	})
+	bolt.SetNotify(func(m revdb.Mapping) {
+		r.feed.publish(tenant, m)
+	})
+
+	var db revdb.DBIface = bolt
+	if r.conf.DB.CacheSize > 0 || r.conf.DB.NegCacheSize > 0 {
+		db = revdb.NewCachedDB(db, r.conf.DB.CacheSize,
+			r.conf.DB.NegCacheSize, r.conf.DB.NegCacheTTL)
+	}
+	return db, nil
+}
+
+//openDefaultDB opens the default tenant's db, retrying until it succeeds,
+//and closes opened. Requests are answered with 503 in the meantime.
+func (r *revDns) openDefaultDB(opened chan struct{}) {
+	for {
+		db, err := r.openDB(defaultTenant)
+		if err == nil {
+			r.dbLock.Lock()
+			r.dbs[defaultTenant] = db
+			r.dbLock.Unlock()
+			close(opened)
+			return
+		}
+		log.Println("Error opening DB:", err)
+		time.Sleep(dbRetry)
+	}
+}
+
+//cacheStats returns cache counters per tenant
+func (r *revDns) cacheStats() map[string]revdb.CacheStats {
+	r.dbLock.Lock()
+	defer r.dbLock.Unlock()
+	stats := make(map[string]revdb.CacheStats)
+	for tenant, db := range r.dbs {
+		if c, ok := db.(*revdb.CachedDB); ok {
+			stats[tenant] = c.Stats()
+		}
+	}
+	return stats
+}
+
+func (r *revDns) limits() revdb.Limits {
+	return revdb.Limits{
+		MaxDomains:   r.conf.DB.MaxDomains,
+		Eviction:     r.conf.DB.Eviction,
+		IntervalGap:  r.conf.DB.IntervalGap,
+		MaxIntervals: r.conf.DB.MaxIntervals,
+	}
+}
+
+func (r *revDns) closeDBs() {
+	r.dbLock.Lock()
+	defer r.dbLock.Unlock()
+	for tenant, db := range r.dbs {
+		db.Close()
+		delete(r.dbs, tenant)
 	}
 }
 
 func (r *revDns) httpHandler() {
 	router := mux.NewRouter()
-	router.Handle("/revdns/api/v1/ip/{ip}", &lkup{c: r.httpReq}).Methods("GET")
-	server := fmt.Sprintf(":%s", strconv.Itoa(r.conf.Api.Port))
-	log.Fatal(http.ListenAndServe(server, router))
+	r.route(router, "/ip/_bulk", auth.RoleReader, http.HandlerFunc(r.bulkHandler), "POST")
+	r.route(router, "/ip/{ip}", auth.RoleReader, &lkup{c: r.httpReq}, "GET")
+	r.route(router, "/domain/{domain}", auth.RoleReader, http.HandlerFunc(r.domainHandler), "GET")
+	r.route(router, "/ip/{ip}", auth.RoleAdmin, http.HandlerFunc(r.purgeHandler), "DELETE")
+	r.route(router, "/ip/{ip}/domain/{domain}", auth.RoleAdmin, http.HandlerFunc(r.purgeHandler), "DELETE")
+	r.route(router, "/domain/{domain}", auth.RoleAdmin, http.HandlerFunc(r.purgeHandler), "DELETE")
+	r.route(router, "/purge", auth.RoleAdmin, http.HandlerFunc(r.purgeHandler), "POST")
+	r.route(router, "/cidr/{ip}/{bits:[0-9]+}", auth.RoleReader, http.HandlerFunc(r.cidrHandler), "GET")
+	r.routeUntimed(router, "/scan/unranked", auth.RoleReader, http.HandlerFunc(r.unrankedHandler), "GET")
+	r.route(router, "/pdns/query/{value}", auth.RoleReader, http.HandlerFunc(r.pdnsHandler), "GET")
+	r.routeUntimed(router, "/zeek/conn", auth.RoleReader, http.HandlerFunc(r.zeekHandler), "POST")
+	r.routeUntimed(router, "/feed", auth.RoleReader, http.HandlerFunc(r.feedHandler), "GET")
+	r.route(router, "/annotations", auth.RoleReader, http.HandlerFunc(r.searchAnnotations), "GET")
+	r.route(router, "/annotations/{kind}/{key}", auth.RoleReader, http.HandlerFunc(r.getAnnotations), "GET")
+	r.route(router, "/annotations/{kind}/{key}", auth.RoleAdmin, http.HandlerFunc(r.addAnnotation), "POST")
+	router.Handle("/revdns/api/v1/stats", instrument("/stats",
+		r.authorize(auth.RoleAdmin, http.HandlerFunc(r.statsHandler)))).Methods("GET")
+	//scrapers only need to read, without auth anonymous scrapes work
+	router.Handle("/metrics", r.authorize(auth.RoleReader, promhttp.Handler())).Methods("GET")
+	router.HandleFunc("/healthz", r.healthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", r.readyzHandler).Methods("GET")
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, errNotFound)
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, newAPIError(http.StatusMethodNotAllowed, "method not allowed"))
+	})
+	if r.conf.Api.GrpcPort != 0 {
+		log.Println("Starting grpc handler")
+		go r.grpcHandler()
+	}
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%s", strconv.Itoa(r.conf.Api.Port)),
+		Handler:   router,
+		TLSConfig: r.tlsConf,
+	}
+	if r.tlsConf != nil {
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(server.ListenAndServe())
+}
+
+//route registers an api path for the default tenant and under
+///t/{tenant}, for callers holding role on the tenant and within their
+//rate limit. Authorized requests are audit logged and bound by the api
+//timeout.
+func (r *revDns) route(router *mux.Router, path string, role string, h http.Handler, methods ...string) {
+	r.routeUntimed(router, path, role, withTimeout(h, r.conf.Api.Timeout), methods...)
+}
+
+//routeUntimed registers a route like route without the api timeout, for
+//event streams, scans and uploads that outlast it
+func (r *revDns) routeUntimed(router *mux.Router, path string, role string, h http.Handler, methods ...string) {
+	h = instrument(path, r.authorize(role, r.auditRequests(path, r.rateLimit(path, h))))
+	router.Handle("/revdns/api/v1"+path, h).Methods(methods...)
+	router.Handle("/revdns/api/v1/t/{tenant}"+path, h).Methods(methods...)
 }
 
 func (r *revDns) Start() {
 	log.Println("Starting DB handler ...")
+	if err := r.limits().Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if enc := r.conf.DB.Encoding; enc != "binary" && enc != "json" {
+		log.Fatalf("unknown db encoding %q", enc)
+	}
+	tlsConf, err := tlsConfig(r.conf.Api.TLS)
+	if err != nil {
+		log.Fatal("Error setting up tls: ", err)
+	}
+	r.tlsConf = tlsConf
+	r.registerMetrics()
 	go r.dbHandler()
 
 	log.Println("Starting http handler")
 	go r.httpHandler()
+
+	if r.conf.DNS.Enabled {
+		log.Println("Starting PTR handler")
+		go r.ptrHandler()
+	}
 }
 
 //Handle DB lookups and updates
 func (r *revDns) dbHandler() {
-	db := revdb.NewDefaultBoltDB()
-	if db == nil {
-		log.Fatal("Error opening DB")
-	}
-	r.db = db
-	defer db.Close()
+	opened := make(chan struct{})
+	go r.openDefaultDB(opened)
+	defer r.closeDBs()
+
+	//writes queue up until the db is open
+	var writer chan writeReq
 	for {
 		select {
+		case <-opened:
+			writer = r.writer
+			opened = nil
+
 		case webreq := <-r.httpReq:
 			go func(httpReq lkupReq) {
-				rsp, err := db.ReadDB(httpReq.ip)
-				if err == nil {
-					log.Println("Responding ...", rsp)
-					json.NewEncoder(httpReq.w).Encode(rsp)
-					httpReq.c <- struct{}{}
+				//the client gave up while the request was queued
+				if httpReq.ctx.Err() != nil {
+					return
 				}
+				httpReq.rsp <- r.lookup(httpReq)
 			}(webreq)
 
-		case wr := <-r.writer:
+		case wr := <-writer:
 			go func(w writeReq) {
 				//log.Println("Write request :", w)
-				db.WriteDB(wr.ip, wr.domains)
-				wr.c <- struct{}{}
+				db, err := r.tenantDB(w.tenant)
+				if err != nil {
+					log.Println("Dropping write:", err)
+				} else {
+					db.WriteDB(w.ip, w.domains, w.ev)
+				}
+				if w.c != nil {
+					w.c <- struct{}{}
+				}
 			}(wr)
 		}
 	}
 }
+
+//lookup serves a single ip lookup
+func (r *revDns) lookup(req lkupReq) lkupRsp {
+	db, err := r.tenantDB(req.tenant)
+	if err != nil {
+		return lkupRsp{err: err}
+	}
+	val, err := db.ReadDB(req.ip)
+	if err != nil {
+		log.Println("Error reading DB:", err)
+		return lkupRsp{err: err}
+	}
+	if len(val.Domains) == 0 {
+		return lkupRsp{err: newAPIError(http.StatusNotFound, "unknown ip %s", req.ip)}
+	}
+	val = req.filter.apply(val)
+	if err := annotate(db, req.ip, &val); err != nil {
+		log.Println("Error reading annotations:", err)
+	}
+	return lkupRsp{val: val}
+}
+
+//lookupDomain serves a domain lookup
+func (r *revDns) lookupDomain(tenant string, domain string) (map[string]revdb.DnsInfo, error) {
+	db, err := r.tenantDB(tenant)
+	if err != nil {
+		return nil, err
+	}
+	dr, ok := db.(revdb.DomainReader)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, "domain lookups not supported")
+	}
+	ips, err := dr.ReadDomain(domain)
+	if err != nil {
+		log.Println("Error reading DB:", err)
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, newAPIError(http.StatusNotFound, "unknown domain %s", domain)
+	}
+	return ips, nil
+}
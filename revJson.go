@@ -2,8 +2,10 @@ package main
 
 import (
 	"log"
+	"time"
 
 	"github.com/antonholmquist/jason"
+	"github.com/gviz/revDNS/internal/revdb"
 )
 
 //Wrapper API for JSON parsing
@@ -30,6 +32,18 @@ func (js *revJson) getValStrSlice(key string) ([]string, error) {
 	return js.root.GetStringArray(key)
 }
 
+//getValTime reads epoch (bro default) or RFC3339 timestamps
+func (js *revJson) getValTime(key string) (time.Time, error) {
+	if ts, err := js.root.GetFloat64(key); err == nil {
+		return revdb.EpochTime(ts)
+	}
+	ts, err := js.getValStr(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return revdb.ParseTime(ts)
+}
+
 func (js *revJson) compareValStr(key string, val string) (int, error) {
 	if v, err := js.getValStr(key); err != nil {
 		if v == val {
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gviz/revDNS/internal/revdb"
+)
+
+//defaultScanSince how far back the unranked scan looks by default
+const defaultScanSince = 24 * time.Hour
+
+//cidrAddrs returns the addresses of a prefix, at most max
+func cidrAddrs(n *net.IPNet, max int) ([]string, error) {
+	ones, bits := n.Mask.Size()
+	if host := uint(bits - ones); host >= 62 || 1<<host > max {
+		return nil, fmt.Errorf("/%d is too large, at most %d addresses", ones, max)
+	}
+	ips := make([]string, 0, 1<<uint(bits-ones))
+	ip := append(net.IP(nil), n.IP...)
+	for ; n.Contains(ip); inc(ip) {
+		ips = append(ips, ip.String())
+		if len(ips) == cap(ips) {
+			break
+		}
+	}
+	return ips, nil
+}
+
+//inc increments ip in place
+func inc(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
+}
+
+//cidrHandler streams the known ips of a prefix as NDJSON, prefixes are
+//limited to api.bulk_max addresses
+func (r *revDns) cidrHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	cidr := vars["ip"] + "/" + vars["bits"]
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		badRequest(w, "invalid cidr %q", cidr)
+		return
+	}
+	filter, err := newLkupFilter(req.URL.Query())
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	ips, err := cidrAddrs(n, r.conf.Api.BulkMax)
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	db, err := r.tenantDB(tenantOf(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	br, ok := db.(revdb.BulkReader)
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotImplemented, "cidr lookups not supported"))
+		return
+	}
+
+	rw := newRecordWriter(w)
+	err = br.ReadDBMulti(ips, func(ip string, val revdb.DnsVal) error {
+		if err := req.Context().Err(); err != nil {
+			return err
+		}
+		if len(val.Domains) == 0 {
+			return nil
+		}
+		if val = filter.apply(val); len(val.Domains) == 0 {
+			return nil
+		}
+		auditResults(req, len(val.Domains))
+		return rw.write(revdb.Record{IP: ip, DnsVal: val})
+	})
+	if err != nil {
+		log.Println("Cidr lookup failed:", err)
+	}
+}
+
+//scanSince reads ?since= as a duration back from now or a time
+func scanSince(q url.Values, now time.Time) (int64, error) {
+	since := q.Get("since")
+	if since == "" {
+		return now.Add(-defaultScanSince).Unix(), nil
+	}
+	if d, err := time.ParseDuration(since); err == nil && d > 0 {
+		return now.Add(-d).Unix(), nil
+	}
+	t, err := revdb.ParseTime(since)
+	if err != nil {
+		return 0, fmt.Errorf("invalid since %q", since)
+	}
+	return t.Unix(), nil
+}
+
+//unranked reports whether none of val's domains is ranked and one was
+//seen since
+func unranked(val revdb.DnsVal, since int64) bool {
+	recent := false
+	for _, info := range val.Domains {
+		if info.WlId != 0 {
+			return false
+		}
+		if info.SeenBetween(since, math.MaxInt64) {
+			recent = true
+		}
+	}
+	return recent
+}
+
+//unrankedHandler lists the ips whose domains are all unranked and that
+//were seen recently, a scan of the db from ?after=. Results are buffered
+//so the scan doesn't wait on the client. A scan stopped at the limit
+//sets X-Next-After to the ip to resume after.
+func (r *revDns) unrankedHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	since, err := scanSince(q, time.Now())
+	if err != nil {
+		badRequest(w, "%s", err)
+		return
+	}
+	limit := r.conf.Api.BulkMax
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > limit {
+			badRequest(w, "invalid limit %q, at most %d", l, limit)
+			return
+		}
+		limit = n
+	}
+	after := q.Get("after")
+	if after != "" && net.ParseIP(after) == nil {
+		badRequest(w, "invalid after %q", after)
+		return
+	}
+	db, err := r.tenantDB(tenantOf(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s, ok := db.(revdb.PagedScanner)
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotImplemented, "scans not supported"))
+		return
+	}
+
+	var recs []revdb.Record
+	err = s.ScanDBAfter(after, func(ip string, val revdb.DnsVal) error {
+		if err := req.Context().Err(); err != nil {
+			return err
+		}
+		if !unranked(val, since) {
+			return nil
+		}
+		if recs = append(recs, revdb.Record{IP: ip, DnsVal: val}); len(recs) == limit {
+			return errScanDone
+		}
+		return nil
+	})
+	if err == errScanDone {
+		w.Header().Set("X-Next-After", recs[len(recs)-1].IP)
+	} else if err != nil {
+		log.Println("Unranked scan failed:", err)
+		writeError(w, err)
+		return
+	}
+	rw := newRecordWriter(w)
+	for _, rec := range recs {
+		auditResults(req, len(rec.Domains))
+		if err := rw.write(rec); err != nil {
+			return
+		}
+	}
+}
+
+var errScanDone = fmt.Errorf("scan limit reached")
@@ -1,12 +1,24 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/gviz/revDNS/internal/revconfig"
+	"github.com/gviz/revDNS/internal/revdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+var errMissingField = errors.New("missing field")
+
+//kafkaRetry delay between kafka connection attempts
+const kafkaRetry = 5 * time.Second
+
 //Input Stream Handling .
 type stream struct {
 	brokers   []string
@@ -15,119 +27,282 @@ type stream struct {
 	pConsumer sarama.PartitionConsumer
 	writer    chan writeReq
 	conf      *revconfig.RevConfig
+
+	lock      sync.Mutex
+	connected bool
+	lastErr   error
+	//failing the consumer returned errors and no message since
+	failing bool
+	//offset of the last consumed message, -1 before the first one
+	offset int64
 }
 
 func (s *stream) Init(conf *revconfig.RevConfig) {
-	if len(s.brokers) == 0 {
-		log.Panic("No brokers specified for kafka..")
+	s.conf = conf
+	s.offset = -1
+	//lag is read on every scrape so it keeps growing while ingestion stalls
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "revdns_kafka_lag",
+		Help:        "Messages behind the partition's high water mark.",
+		ConstLabels: prometheus.Labels{"topic": s.topic, "partition": "0"},
+	}, s.lag)
+}
+
+//lag messages between the last consumed one and the high water mark
+//last seen, which is kept while disconnected
+func (s *stream) lag() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.pConsumer == nil || s.offset < 0 {
+		return 0
+	}
+	return float64(s.pConsumer.HighWaterMarkOffset() - s.offset - 1)
+}
+
+//connect opens the partition consumer
+func (s *stream) connect() error {
+	if len(s.brokers) == 0 || s.brokers[0] == "" {
+		return errors.New("no brokers specified for kafka")
 	}
 	log.Printf("Kafka Brokers: %v", s.brokers)
 
-	consumer, err := sarama.NewConsumer(s.brokers, nil)
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = true
+	consumer, err := sarama.NewConsumer(s.brokers, config)
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+	//resume after the last consumed message so nothing produced while
+	//kafka was down is skipped
+	start := sarama.OffsetNewest
+	s.lock.Lock()
+	if s.offset >= 0 {
+		start = s.offset + 1
+	}
+	s.lock.Unlock()
+	pConsumer, err := consumer.ConsumePartition(s.topic, 0, start)
+	if err == sarama.ErrOffsetOutOfRange {
+		log.Printf("Offset %d no longer retained, resuming from the oldest message", start)
+		pConsumer, err = consumer.ConsumePartition(s.topic, 0, sarama.OffsetOldest)
 	}
-
-	s.consumer = consumer
-	pConsumer, err := consumer.ConsumePartition(s.topic, 0, sarama.OffsetNewest)
 	if err != nil {
-		log.Fatalln(err)
+		consumer.Close()
+		return err
 	}
-
+	s.lock.Lock()
+	s.consumer = consumer
 	s.pConsumer = pConsumer
-	s.conf = conf
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *stream) setStatus(connected bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.connected = connected
+	s.lastErr = err
+	s.failing = false
+}
+
+//status reports whether the consumer is connected, its last error and
+//how many messages it is behind. A consumer returning errors without
+//delivering messages, as during broker outages, isn't connected.
+func (s *stream) status() (bool, error, int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	connected := s.connected && !s.failing
+	var lag int64
+	if connected && s.offset >= 0 {
+		lag = s.pConsumer.HighWaterMarkOffset() - s.offset - 1
+	}
+	return connected, s.lastErr, lag
+}
+
+//Sensor name from the record, falling back to the configured one
+func (s *stream) sensor(js *revJson) string {
+	if s.conf.Kafka.SensorField != "" {
+		if name, err := js.getValStr(s.conf.Kafka.SensorField); err == nil && name != "" {
+			return name
+		}
+	}
+	return s.conf.Kafka.Sensor
+}
+
+//Tenant from the record, falling back to the input's tenant
+func (s *stream) tenant(js *revJson) string {
+	if s.conf.Kafka.TenantField != "" {
+		if name, err := js.getValStr(s.conf.Kafka.TenantField); err == nil && name != "" {
+			return strings.ToLower(name)
+		}
+	}
+	return strings.ToLower(s.conf.Kafka.Tenant)
+}
+
+//Observation time of the record, zero if it has none
+func (s *stream) ts(js *revJson) time.Time {
+	ts, err := js.getValTime("ts")
+	if err != nil {
+		return time.Time{}
+	}
+	return ts
 }
 
 //DNS responses
-func (s *stream) processDNS(js *revJson) {
+func (s *stream) processDNS(js *revJson) error {
 	qtype, err := js.getValStr("qtype_name")
 	if qtype != "A" && qtype != "AAA" {
-		return
+		return nil
 	}
 
 	answers, err := js.getValStrSlice("answers")
 	if err != nil {
-		return
+		//unanswered queries
+		return nil
 	}
 	query, err := js.getValStr("query")
 	if err != nil {
-		return
+		return errMissingField
 	}
 
 	for indx := range answers {
 		//fmt.Println(string(answers[indx]))
 		s.writer <- writeReq{
+			tenant:  s.tenant(js),
 			ip:      answers[indx],
 			domains: []string{query},
+			ev: revdb.Evidence{
+				Source: revdb.SourceDNS,
+				Sensor: s.sensor(js),
+				Ts:     s.ts(js),
+			},
 		}
 	}
+	return nil
 }
 
 //SSL SNI
-func (s *stream) processSSL(js *revJson) {
+func (s *stream) processSSL(js *revJson) error {
 	server, err := js.getValStr("server_name")
 	if err != nil {
-		return
+		//no SNI
+		return nil
 	}
 
 	host, err := js.getValStr("id_resp_h")
 	if err != nil {
-		return
+		return errMissingField
 	}
 
 	s.writer <- writeReq{
+		tenant:  s.tenant(js),
 		ip:      host,
 		domains: []string{server},
+		ev: revdb.Evidence{
+			Source: revdb.SourceSSL,
+			Sensor: s.sensor(js),
+			Ts:     s.ts(js),
+		},
 	}
+	return nil
 }
 
 //Extract Host Fields
-func (s *stream) processHTTP(js *revJson) {
+func (s *stream) processHTTP(js *revJson) error {
 	server, err := js.getValStr("host")
 	if err != nil {
-		return
+		//no Host header
+		return nil
 	}
 
 	host, err := js.getValStr("id_resp_h")
 	if err != nil {
-		return
+		return errMissingField
 	}
 
 	s.writer <- writeReq{
+		tenant:  s.tenant(js),
 		ip:      host,
 		domains: []string{server},
+		ev: revdb.Evidence{
+			Source: revdb.SourceHTTP,
+			Sensor: s.sensor(js),
+			Ts:     s.ts(js),
+		},
 	}
+	return nil
 }
 
+//Run consumes the input, reconnecting whenever the consumer fails.
+//The api keeps serving while kafka is unavailable.
 func (s *stream) Run() {
 	log.Println("Run...")
+	for {
+		if err := s.connect(); err != nil {
+			log.Println("Error connecting to kafka:", err)
+			s.setStatus(false, err)
+			time.Sleep(kafkaRetry)
+			continue
+		}
+		s.setStatus(true, nil)
+		s.consume()
+		s.pConsumer.Close()
+		s.consumer.Close()
+		s.setStatus(false, errors.New("consumer closed"))
+	}
+}
+
+func (s *stream) consume() {
 	for {
 		select {
-		case msg := <-s.pConsumer.Messages():
-			go func(val []byte) {
-				js := NewRevJson(val)
-				if js == nil {
-					log.Println("Error getting json object")
-					return
-				}
-				sType, err := js.getValStr("@stream")
-				if err != nil {
-					return
-				}
-				//				log.Println("stype:", sType)
-				switch sType {
-				case s.conf.Kafka.DnsStream:
-					//log.Println(string(val))
-					s.processDNS(js)
-				case s.conf.Kafka.SslStream:
-					s.processSSL(js)
-				case s.conf.Kafka.HttpStream:
-					s.processHTTP(js)
-				default:
-					return
-				}
-			}(msg.Value)
+		case msg, ok := <-s.pConsumer.Messages():
+			if !ok {
+				return
+			}
+			s.lock.Lock()
+			s.offset = msg.Offset
+			s.failing = false
+			s.lock.Unlock()
+			go s.process(msg.Value)
 
+		case err, ok := <-s.pConsumer.Errors():
+			if !ok {
+				return
+			}
+			log.Println("Kafka error:", err)
+			s.lock.Lock()
+			s.lastErr = err
+			s.failing = true
+			s.lock.Unlock()
 		}
 	}
 }
+
+func (s *stream) process(val []byte) {
+	js := NewRevJson(val)
+	if js == nil {
+		log.Println("Error getting json object")
+		parseErrors.WithLabelValues("").Inc()
+		return
+	}
+	sType, err := js.getValStr("@stream")
+	if err != nil {
+		parseErrors.WithLabelValues("").Inc()
+		return
+	}
+	//				log.Println("stype:", sType)
+	switch sType {
+	case s.conf.Kafka.DnsStream:
+		//log.Println(string(val))
+		err = s.processDNS(js)
+	case s.conf.Kafka.SslStream:
+		err = s.processSSL(js)
+	case s.conf.Kafka.HttpStream:
+		err = s.processHTTP(js)
+	default:
+		return
+	}
+	kafkaMessages.WithLabelValues(sType).Inc()
+	if err != nil {
+		parseErrors.WithLabelValues(sType).Inc()
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gviz/revDNS/internal/revconfig"
+)
+
+//certCheckInterval how often the certificate files are checked for
+//changes
+const certCheckInterval = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//certReloader serves a certificate and key pair, reloading them when
+//the files change on disk. A pair that fails to load is logged and the
+//previous one kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	now      func() time.Time
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//modified returns the latest modification time of the pair
+func (c *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) reload() error {
+	mod, err := c.modified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime = &cert, mod
+	return nil
+}
+
+//GetCertificate returns the current certificate for tls.Config
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if now := c.now(); now.Sub(c.checked) >= certCheckInterval {
+		c.checked = now
+		if mod, err := c.modified(); err != nil {
+			log.Println("Error checking certificate:", err)
+		} else if !mod.Equal(c.modTime) {
+			if err := c.reload(); err != nil {
+				log.Println("Error reloading certificate, keeping the old one:", err)
+			} else {
+				log.Printf("Reloaded certificate %s\n", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+//tlsConfig returns the api's TLS config, nil when TLS isn't configured
+func tlsConfig(conf revconfig.TLSConfig) (*tls.Config, error) {
+	if conf.Cert == "" && conf.Key == "" {
+		if conf.ClientCA != "" {
+			return nil, errors.New("api.tls.client_ca needs api.tls.cert")
+		}
+		return nil, nil
+	}
+	version, ok := tlsVersions[conf.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown tls version %q", conf.MinVersion)
+	}
+	certs, err := newCertReloader(conf.Cert, conf.Key)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     version,
+		GetCertificate: certs.GetCertificate,
+	}
+	if conf.ClientCA != "" {
+		pem, err := ioutil.ReadFile(conf.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates", conf.ClientCA)
+		}
+		//clients may still authenticate by key or token
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if conf.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if conf.RequireClientCert {
+		return nil, errors.New("api.tls.require_client_cert needs api.tls.client_ca")
+	}
+	return cfg, nil
+}
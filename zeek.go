@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gviz/revDNS/internal/revdb"
+	"github.com/gviz/revDNS/internal/zeek"
+)
+
+//maxZeekUpload largest conn log enriched by the api, bigger logs are
+//better enriched with zeekenrich next to the db
+const maxZeekUpload = 256 << 20
+
+//dbLookup returns a zeek.Lookup reading the known ips from br
+func dbLookup(br revdb.BulkReader, done func() error) zeek.Lookup {
+	return func(ips []string) (map[string]revdb.DnsVal, error) {
+		if err := done(); err != nil {
+			return nil, err
+		}
+		vals := make(map[string]revdb.DnsVal)
+		err := br.ReadDBMulti(ips, func(ip string, val revdb.DnsVal) error {
+			if len(val.Domains) > 0 {
+				vals[ip] = val
+			}
+			return nil
+		})
+		return vals, err
+	}
+}
+
+//zeekErrorTrailer reports enrichments that failed after the log started
+const zeekErrorTrailer = "X-Revdns-Error"
+
+//zeekHandler returns an uploaded Zeek conn log, TSV or JSON, with the
+//domains and whitelist rank of its responders, and of its originators
+//with ?orig=true. The upload is spooled to a temp file first, HTTP/1.1
+//servers stop reading request bodies once the response starts. The
+//log is then streamed back, a failure part way through ends it with a
+//#error line, or an error object for JSON logs, and sets the
+//X-Revdns-Error trailer.
+func (r *revDns) zeekHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	opts := zeek.Options{Orig: q.Get("orig") == "true"}
+	if top := q.Get("top"); top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil || n <= 0 {
+			badRequest(w, "invalid top %q", top)
+			return
+		}
+		opts.Top = n
+	}
+	db, err := r.tenantDB(tenantOf(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	br, ok := db.(revdb.BulkReader)
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotImplemented, "bulk lookups not supported"))
+		return
+	}
+
+	spool, err := spoolUpload(req.Body, maxZeekUpload)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	body := bufio.NewReader(spool)
+	first, err := peekNonSpace(body)
+	if err != nil && err != io.EOF {
+		badRequest(w, "%s", err)
+		return
+	}
+	if first == '{' {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Trailer", zeekErrorTrailer)
+	n, err := zeek.Enrich(body, w, dbLookup(br, req.Context().Err), opts)
+	auditResults(req, n)
+	if err != nil {
+		log.Println("Zeek enrichment failed:", err)
+		zeekFailed(w, first == '{', err)
+	}
+}
+
+//spoolUpload copies an upload of at most max bytes to a temp file and
+//rewinds it
+func spoolUpload(body io.Reader, max int64) (*os.File, error) {
+	f, err := ioutil.TempFile("", "revdns-upload")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(f, io.LimitReader(body, max+1))
+	if err == nil && n > max {
+		err = newAPIError(http.StatusRequestEntityTooLarge, "upload larger than %d bytes", max)
+	} else if err != nil {
+		err = newAPIError(http.StatusBadRequest, "%s", err)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+//zeekFailed marks an enriched log as cut short by err
+func zeekFailed(w http.ResponseWriter, isJSON bool, err error) {
+	w.Header().Set(zeekErrorTrailer, err.Error())
+	if isJSON {
+		json.NewEncoder(w).Encode(map[string]string{"revdns_error": err.Error()})
+		return
+	}
+	fmt.Fprintf(w, "#error\t%s\n", strings.Replace(err.Error(), "\n", " ", -1))
+}